@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/rubiojr/tavern/server"
+)
+
+// Uploads a file in chunks of c.config.ChunkSize, resuming the upload from
+// the last chunk acknowledged by the server.
+func (c *Client) uploadChunked(ctx context.Context, token string, fsys fs.FS, mf *manifestFile) error {
+	offset, err := c.uploadOffset(ctx, token, mf.SHA256)
+	if err != nil {
+		return err
+	}
+
+	if offset >= mf.Size {
+		c.emit(Event{Type: EventUploaded, Path: mf.Path, Size: mf.Size})
+		return nil
+	}
+
+	c.emit(Event{Type: EventUploading, Path: mf.Path, Size: mf.Size, Offset: offset})
+
+	src := &chunkSource{fsys: fsys, path: mf.src}
+	defer src.Close()
+
+	attempt := 0
+	for offset < mf.Size {
+		err = src.seek(offset)
+		if err != nil {
+			return err
+		}
+
+		size := c.config.ChunkSize
+		if mf.Size-offset < size {
+			size = mf.Size - offset
+		}
+
+		progress := &progressCounter{c: c, mf: mf, n: offset, r: io.LimitReader(src, size)}
+		chunk := &guardedReader{r: progress}
+		next, err := c.uploadChunk(ctx, token, mf, offset, chunk, size)
+		chunk.Close()
+		if err == nil {
+			offset, attempt = next, 0
+			continue
+		}
+
+		var rerr *retryError
+		if !errors.As(err, &rerr) || attempt >= c.config.Retries {
+			return err
+		}
+		err = c.backoff(ctx, attempt, rerr)
+		if err != nil {
+			return err
+		}
+		attempt++
+
+		// The server may have saved the chunk before failing, resume from
+		// the offset it acknowledged
+		offset, err = c.uploadOffset(ctx, token, mf.SHA256)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.emit(Event{Type: EventUploaded, Path: mf.Path, Size: mf.Size})
+	return nil
+}
+
+// File being uploaded in chunks, that can be repositioned to resume
+// uploads.
+type chunkSource struct {
+	fsys fs.FS
+	path string
+	f    fs.File
+	pos  int64
+}
+
+func (s *chunkSource) Read(p []byte) (int, error) {
+	n, err := s.f.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+// Moves to offset, seeking if the file supports it, or reading it from the
+// start otherwise.
+func (s *chunkSource) seek(offset int64) error {
+	if s.f != nil && offset == s.pos {
+		return nil
+	}
+
+	if seeker, ok := s.f.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		s.pos = offset
+		return nil
+	}
+
+	if s.f == nil || offset < s.pos {
+		s.Close()
+		f, err := s.fsys.Open(s.path)
+		if err != nil {
+			return err
+		}
+		s.f, s.pos = f, 0
+	}
+
+	_, err := io.CopyN(ioutil.Discard, s, offset-s.pos)
+	return err
+}
+
+func (s *chunkSource) Close() error {
+	if s.f == nil {
+		return nil
+	}
+
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// Returns the number of bytes of the file with the given hash the server
+// has received.
+func (c *Client) uploadOffset(ctx context.Context, token, sum string) (int64, error) {
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.url(server.BlobsRoute+"/"+sum), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", fmt.Sprintf("bearer %s", token))
+		return req, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("upload status failed: %s", resp.Status)
+	}
+
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// Sends a chunk of size bytes starting at offset, and returns the new offset
+// acknowledged by the server.
+func (c *Client) uploadChunk(ctx context.Context, token string, mf *manifestFile, offset int64, chunk io.Reader, size int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, c.url(server.BlobsRoute+"/"+mf.SHA256), chunk)
+	if err != nil {
+		return offset, err
+	}
+	req.ContentLength = size
+	req.Header.Add("Authorization", fmt.Sprintf("bearer %s", token))
+	req.Header.Add("Content-Type", "application/octet-stream")
+	req.Header.Add("Upload-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Add("Upload-Length", strconv.FormatInt(mf.Size, 10))
+
+	resp, err := c.httpClient().Do(req)
+	if rerr := retryable(ctx, resp, err); rerr != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		rerr.err = fmt.Errorf("uploading %s failed: %w", mf.Path, rerr.err)
+		return offset, rerr
+	}
+	if err != nil {
+		return offset, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		errStatus, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return offset, err
+		}
+		return offset, fmt.Errorf("uploading %s failed: %s", mf.Path, errStatus)
+	}
+
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// Reader that stops reading once closed, so the HTTP transport can't keep
+// reading a failed chunk while the next attempt repositions the file.
+type guardedReader struct {
+	mu     sync.Mutex
+	r      io.Reader
+	closed bool
+}
+
+func (g *guardedReader) Read(p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	return g.r.Read(p)
+}
+
+func (g *guardedReader) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+	return nil
+}
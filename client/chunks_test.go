@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	cfs "github.com/charmbracelet/charm/fs"
+	"github.com/rubiojr/tavern/internal/testutil"
+	"github.com/rubiojr/tavern/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumeChunkedUpload(t *testing.T) {
+	const content = "0123456789abcdef"
+	// Not the default test server address, cmd tests may run in parallel
+	const serverAddr = "127.0.0.1:8002"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tav := server.NewServerWithConfig(&server.Config{Addr: serverAddr, UploadsPath: t.TempDir()})
+	go tav.Serve(ctx)
+	if !testutil.WaitForServer(serverAddr) {
+		assert.FailNow(t, "tavern server did not start")
+	}
+
+	cfg := DefaultConfig()
+	cfg.ServerURL = "http://" + serverAddr
+	cfg.CharmServerHost = testutil.CharmServerHost
+	cfg.ChunkSize = 4
+	c, err := NewClientWithConfig(cfg)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	charmfs, err := cfs.NewFSWithClient(c.charmClient)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	err = testutil.WriteCharmFile(charmfs, "testdata/chunked.txt", content)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	jwt, err := c.charmClient.JWT("tavern")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	// Upload the first chunk only, like an interrupted publish would
+	sum := sha256.Sum256([]byte(content))
+	mf := &manifestFile{Path: "testdata/chunked.txt", SHA256: hex.EncodeToString(sum[:]), Size: int64(len(content))}
+	offset, err := c.uploadChunk(ctx, jwt, mf, 0, strings.NewReader(content[:4]), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), offset)
+
+	offset, err = c.uploadOffset(ctx, jwt, mf.SHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), offset)
+
+	// Resending the first chunk is rejected, publishing has to resume
+	_, err = c.uploadChunk(ctx, jwt, mf, 0, strings.NewReader(content[:4]), 4)
+	assert.Error(t, err)
+
+	events := []Event{}
+	cfg.OnEvent = func(e Event) { events = append(events, e) }
+	res, err := c.Publish("testdata/chunked.txt")
+	assert.NoError(t, err)
+	assert.Contains(t, events, Event{Type: EventUploading, Path: mf.Path, Size: mf.Size, Offset: 4})
+
+	id, err := charmId(jwt)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg.ServerURL+"/"+id, res.URL)
+	assert.Equal(t, 1, res.Files)
+	assert.Equal(t, 1, res.Uploaded)
+	assert.Equal(t, mf.Size, res.Bytes)
+	assert.NotEmpty(t, res.Deployment.ID)
+	resp, err := http.Get(cfg.ServerURL + "/" + id + "/testdata/chunked.txt")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(out))
+}
@@ -1,24 +1,44 @@
 package client
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/charm/client"
 	cfs "github.com/charmbracelet/charm/fs"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/rubiojr/tavern/server"
+	"github.com/rubiojr/tavern/server/storage"
 )
 
 const DefaultCharmServerHost = "https://cloud.charm.sh"
 const DefaultServerURL = "http://localhost:8000"
 const DefaultCharmServerHTTPort = 35354
 const DefaultCharmServerSSHPort = 35353
+const DefaultChunkSize = 8 << 20
+
+// Paths with this prefix are published from the local filesystem
+const LocalScheme = "file://"
+
+// ErrCharmAccount is returned by operations that need a Charm account when
+// the client is configured with a deploy token.
+var ErrCharmAccount = errors.New("a Charm account is required, deploy tokens can only publish local files")
 
 type Client struct {
 	remoteFS    *cfs.FS
@@ -26,11 +46,82 @@ type Client struct {
 	config      *Config
 }
 
+type manifest struct {
+	Files []*manifestFile `json:"files"`
+}
+
+// File to publish, listed in the manifest sent to the server
+type manifestFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// Path in the source filesystem
+	src string
+}
+
+type uploadResponse struct {
+	Deployment *Deployment `json:"deployment"`
+	Deleted    []string    `json:"deleted"`
+	// Path of the site in the server
+	Site string `json:"site"`
+}
+
+// Files that would be published by a dry run
+type DryRun struct {
+	// Public URL of the site at /<charm-id>, even if it has a name. Empty
+	// for clients with a deploy token.
+	URL   string        `json:"url"`
+	Files []*DryRunFile `json:"files"`
+	// Total size of the files
+	Size int64 `json:"size"`
+	// Exclusion patterns applied, and where they come from
+	Patterns []string `json:"patterns"`
+}
+
+type DryRunFile struct {
+	Path string `json:"path"`
+	// Empty for clients with a deploy token
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+}
+
 type Config struct {
 	ServerURL           string
 	CharmServerHost     string
 	CharmServerHTTPPort int
 	CharmServerSSHPort  int
+	// Deploy token to publish with instead of a Charm account. Clients
+	// with a token can only publish local files, and don't need Charm keys.
+	Token string
+	// Site of the Charm account to publish to and manage, the default site
+	// if empty. Every site has its own URL and deployments.
+	Site string
+	// Delete the published files not present in the path being published
+	Delete bool
+	// Files larger than this are uploaded in chunks of this size, resuming
+	// interrupted uploads from the last chunk received by the server
+	ChunkSize int64
+	// Gitignore-style patterns excluding files from publishing, in addition
+	// to the ones in the .tavernignore file
+	Exclude []string
+	// Gitignore-style patterns including files excluded otherwise
+	Include []string
+	// Client used for the requests to the Tavern server. Requests don't
+	// time out by default, use contexts or a client with a timeout instead.
+	HTTPClient *http.Client
+	// Files opened at a time from the filesystem being published, so files in
+	// Charm FS are downloaded in parallel
+	Concurrency int
+	// Times failed requests are retried, and wait before the first retry.
+	// Requests creating tokens or site names, rollbacks and deletions are
+	// never retried. Uploads are: if the server published an upload whose
+	// response was lost, the retry publishes the same files again as a new
+	// deployment, taking a place in the rollback history.
+	Retries   int
+	RetryWait time.Duration
+	// Called with progress events while publishing. Events are sent one at a
+	// time, in order.
+	OnEvent func(Event)
 }
 
 func NewClient() (*Client, error) {
@@ -38,10 +129,14 @@ func NewClient() (*Client, error) {
 }
 
 func DefaultConfig() *Config {
-	return &Config{ServerURL: DefaultServerURL, CharmServerHost: DefaultCharmServerHost, CharmServerHTTPPort: DefaultCharmServerHTTPort, CharmServerSSHPort: DefaultCharmServerSSHPort}
+	return &Config{ServerURL: DefaultServerURL, CharmServerHost: DefaultCharmServerHost, CharmServerHTTPPort: DefaultCharmServerHTTPort, CharmServerSSHPort: DefaultCharmServerSSHPort, ChunkSize: DefaultChunkSize, Retries: DefaultRetries, RetryWait: DefaultRetryWait, Concurrency: DefaultConcurrency}
 }
 
 func NewClientWithConfig(cfg *Config) (*Client, error) {
+	if cfg.Token != "" {
+		return &Client{config: cfg}, nil
+	}
+
 	ccfg, err := client.ConfigFromEnv()
 	if err != nil {
 		return nil, err
@@ -72,73 +167,249 @@ func NewClientWithConfig(cfg *Config) (*Client, error) {
 	return &Client{config: cfg, remoteFS: remote, charmClient: c}, nil
 }
 
-func (c *Client) Publish(path string) error {
-	return c.PublishWithRoot("/", path)
+func (c *Client) Publish(path string) (*PublishResult, error) {
+	return c.PublishContext(context.Background(), path)
+}
+
+// PublishContext publishes path, aborting when ctx is done.
+func (c *Client) PublishContext(ctx context.Context, path string) (*PublishResult, error) {
+	return c.PublishWithRootContext(ctx, "/", path)
 }
 
-func (c *Client) PublishWithRoot(root, path string) error {
-	var body *bytes.Buffer
-	var writer *multipart.Writer
+func (c *Client) PublishWithRoot(root, path string) (*PublishResult, error) {
+	return c.PublishWithRootContext(context.Background(), root, path)
+}
 
-	fmt.Printf("Publishing %s\n", path)
-	fmt.Printf("Retrieving files from %s...\n", c.charmClient.Config.Host)
-	f, err := c.remoteFS.Open(path)
+func (c *Client) PublishWithRootContext(ctx context.Context, root, path string) (*PublishResult, error) {
+	res := &PublishResult{Started: time.Now(), Deleted: []string{}, Published: []*PublishedFile{}}
+	c.emit(Event{Type: EventPublishing, Path: path})
+	source, spath, err := c.source(path)
 	if err != nil {
-		return fmt.Errorf("failed to open %s: %w", path, err)
+		return nil, err
+	}
+	if source == c.remoteFS {
+		c.emit(Event{Type: EventRetrieving, URL: c.charmClient.Config.Host})
 	}
-	defer f.Close()
 
-	info, err := f.Stat()
+	files, _, err := c.walk(ctx, source, spath)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
 	}
 
-	fmt.Printf("Publishing to %s\n", c.config.ServerURL)
-	if info.IsDir() {
-		body, writer, err = uploadDir(c.remoteFS, path)
-	} else {
-		body, writer, err = uploadFile(c.remoteFS, path)
+	// Files in Charm FS are downloaded once, and uploaded from a local copy
+	spool := ""
+	if source == c.remoteFS {
+		spool, err = ioutil.TempDir("", "tavern-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(spool)
 	}
+	err = hashFiles(ctx, source, files, c.config.Concurrency, spool)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if spool != "" {
+		source = os.DirFS(spool)
+	}
+	res.ScanDuration = time.Since(res.Started)
+	res.Files = len(files)
+	for _, mf := range files {
+		res.Bytes += mf.Size
 	}
 
-	jwt, err := c.charmClient.JWT("tavern")
+	jwt, err := c.publishToken()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	req, err := c.UploadRequest(jwt, body)
+
+	uploadStarted := time.Now()
+	c.emit(Event{Type: EventConnecting, URL: c.config.ServerURL})
+	missing, err := c.missingFiles(ctx, jwt, files)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("publishing failed: %w", err)
+	}
+	res.Skipped = len(files) - len(missing)
+	if res.Skipped > 0 {
+		c.emit(Event{Type: EventSkipped, Count: res.Skipped})
+	}
+	for _, mf := range files {
+		if missing[mf.Path] {
+			res.Uploaded++
+			res.UploadedBytes += mf.Size
+		}
+		res.Published = append(res.Published, &PublishedFile{Path: mf.Path, Size: mf.Size, SHA256: mf.SHA256, Uploaded: missing[mf.Path]})
+	}
+	if res.Uploaded > 0 {
+		c.emit(Event{Type: EventUploadStarted, Count: res.Uploaded, Size: res.UploadedBytes})
 	}
-	req.Header.Add("Content-Type", writer.FormDataContentType())
 
-	httpc := &http.Client{}
-	resp, err := httpc.Do(req)
+	if c.config.ChunkSize > 0 {
+		for _, mf := range files {
+			if !missing[mf.Path] || mf.Size <= c.config.ChunkSize {
+				continue
+			}
+
+			err = c.uploadChunked(ctx, jwt, source, mf)
+			if err != nil {
+				return nil, fmt.Errorf("publishing failed: %w", err)
+			}
+			delete(missing, mf.Path)
+		}
+	}
+
+	// The files are streamed again from the source if the upload is retried
+	resp, err := c.do(ctx, func() (*http.Request, error) {
+		body, writer := c.uploadFiles(source, files, missing, c.config.Delete)
+		req, err := c.UploadRequestContext(ctx, jwt, body)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		req.Header.Add("Content-Type", writer.FormDataContentType())
+		return req, nil
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		errStatus, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		return fmt.Errorf("publishing failed: %s", errStatus)
+		return nil, fmt.Errorf("publishing failed: %s", errStatus)
 	}
 
-	var id string
-	if id, err = charmId(jwt); err != nil {
-		return err
+	result := &uploadResponse{}
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server response: %w", err)
+	}
+	for _, p := range result.Deleted {
+		c.emit(Event{Type: EventDeleted, Path: p})
+		res.Deleted = append(res.Deleted, p)
 	}
-	fmt.Println("Site published!")
-	fmt.Printf("Visit %s/%s\n", c.config.ServerURL, id)
 
-	return nil
+	// Servers not sending the site publish to the Charm ID
+	id := result.Site
+	if id == "" {
+		if id, err = charmId(jwt); err != nil {
+			return nil, err
+		}
+	}
+	res.URL = fmt.Sprintf("%s/%s", c.config.ServerURL, id)
+	res.Deployment = result.Deployment
+	res.UploadDuration = time.Since(uploadStarted)
+	res.Duration = time.Since(res.Started)
+
+	return res, nil
+}
+
+// Returns the files that would be published from path, and their public URLs,
+// without contacting the Tavern server.
+func (c *Client) DryRun(path string) (*DryRun, error) {
+	return c.DryRunContext(context.Background(), path)
+}
+
+// DryRunContext is DryRun, aborting when ctx is done.
+func (c *Client) DryRunContext(ctx context.Context, path string) (*DryRun, error) {
+	source, spath, err := c.source(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files, ignore, err := c.walk(ctx, source, spath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	dr := &DryRun{Files: []*DryRunFile{}}
+	// The site of deploy tokens is only known by the Tavern server
+	if c.charmClient != nil {
+		id, err := c.charmClient.ID()
+		if err != nil {
+			return nil, err
+		}
+		id, err = storage.SiteNamespace(id, c.config.Site)
+		if err != nil {
+			return nil, err
+		}
+		dr.URL = fmt.Sprintf("%s/%s", c.config.ServerURL, id)
+	}
+	for _, p := range ignore.patterns {
+		dr.Patterns = append(dr.Patterns, p.String())
+	}
+	for _, mf := range files {
+		f := &DryRunFile{Path: mf.Path, Size: mf.Size}
+		if dr.URL != "" {
+			f.URL = dr.URL + "/" + strings.TrimPrefix(mf.Path, "/")
+		}
+		dr.Files = append(dr.Files, f)
+		dr.Size += mf.Size
+	}
+
+	return dr, nil
+}
+
+// Returns the files to publish from spath in the source filesystem, with the
+// exclusion patterns applied.
+func (c *Client) walk(ctx context.Context, source fs.FS, spath string) ([]*manifestFile, *ignoreList, error) {
+	f, err := source.Open(spath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ignore, err := c.ignoreList(source, spath, info.IsDir())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !info.IsDir() {
+		return []*manifestFile{{Path: spath, Size: info.Size(), src: spath}}, ignore, nil
+	}
+
+	files, err := walkFiles(ctx, source, spath, ignore)
+	return files, ignore, err
+}
+
+// Returns the filesystem with the files to publish and the path to
+// publish in it. Paths with the file:// scheme are published from the local
+// filesystem, CharmFS paths otherwise.
+func (c *Client) source(path string) (fs.FS, string, error) {
+	if !strings.HasPrefix(path, LocalScheme) {
+		if c.remoteFS == nil {
+			return nil, "", fmt.Errorf("publishing from Charm FS: %w", ErrCharmAccount)
+		}
+		return c.remoteFS, path, nil
+	}
+
+	lpath, err := filepath.Abs(strings.TrimPrefix(path, LocalScheme))
+	if err != nil {
+		return nil, "", err
+	}
+
+	dir, base := filepath.Split(lpath)
+	if base == "" {
+		return nil, "", fmt.Errorf("can't publish the root directory")
+	}
+
+	return os.DirFS(dir), base, nil
 }
 
-func (c *Client) UploadRequest(token string, body *bytes.Buffer) (*http.Request, error) {
-	req, err := http.NewRequest("POST", fmt.Sprintf(c.config.ServerURL+server.UploadRoute), body)
+func (c *Client) UploadRequest(token string, body io.Reader) (*http.Request, error) {
+	return c.UploadRequestContext(context.Background(), token, body)
+}
+
+func (c *Client) UploadRequestContext(ctx context.Context, token string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url(server.UploadRoute), body)
 	if err != nil {
 		return nil, err
 	}
@@ -148,73 +419,241 @@ func (c *Client) UploadRequest(token string, body *bytes.Buffer) (*http.Request,
 	return req, nil
 }
 
-func uploadDir(cfs fs.FS, root string) (*bytes.Buffer, *multipart.Writer, error) {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	defer writer.Close()
+// Sends the manifest of the files to publish to the server, and returns the
+// ones the server doesn't have.
+func (c *Client) missingFiles(ctx context.Context, token string, files []*manifestFile) (map[string]bool, error) {
+	body, err := json.Marshal(&manifest{Files: files})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &struct {
+		Missing []string `json:"missing"`
+	}{}
+	err = c.apiRequest(ctx, token, http.MethodPost, server.ManifestRoute, body, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := map[string]bool{}
+	for _, p := range resp.Missing {
+		missing[p] = true
+	}
+
+	return missing, nil
+}
+
+// Returns the exclusion patterns for the path being published: the ones in
+// its .tavernignore file, followed by the excluded and included patterns
+// configured.
+func (c *Client) ignoreList(cfs fs.FS, root string, isDir bool) (*ignoreList, error) {
+	ignore := &ignoreList{}
+	if isDir {
+		data, err := fs.ReadFile(cfs, path.Join(root, IgnoreFile))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read %s: %w", IgnoreFile, err)
+		}
+		err = ignore.parse(data, IgnoreFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", IgnoreFile, err)
+		}
+	}
+
+	for _, p := range c.config.Exclude {
+		err := ignore.add(p, "--exclude")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range c.config.Include {
+		err := ignore.add("!"+strings.TrimPrefix(p, "!"), "--include")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ignore, nil
+}
 
+// Returns the files to publish under root, a directory or a single file,
+// skipping the ones excluded by ignore.
+//
+// Files in a directory are published with their path relative to the
+// directory.
+func walkFiles(ctx context.Context, cfs fs.FS, root string, ignore *ignoreList) ([]*manifestFile, error) {
+	files := []*manifestFile{}
 	err := fs.WalkDir(cfs, root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if d == nil || d.IsDir() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d == nil {
 			return nil
 		}
 
 		publishedPath := strings.TrimPrefix(path, root)
-		fmt.Println("Adding ", publishedPath)
-		part, err := writer.CreateFormFile("upload[]", publishedPath)
-		if err != nil {
-			return err
+		rel := strings.TrimPrefix(publishedPath, "/")
+		if rel != "" && ignore.ignored(rel, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || rel == IgnoreFile {
+			return nil
 		}
 
-		f, err := cfs.Open(path)
+		info, err := d.Info()
 		if err != nil {
 			return err
 		}
-		defer f.Close()
+		files = append(files, &manifestFile{Path: publishedPath, Size: info.Size(), src: path})
+		return nil
+	})
 
-		out, err := ioutil.ReadAll(f)
+	return files, err
+}
+
+// Sets the size and hash of files, opening up to concurrency files at a time.
+//
+// If spool is not empty, the files are copied to the spool directory while
+// hashed, and read from there afterwards, so they are only read once from
+// cfs.
+func hashFiles(ctx context.Context, cfs fs.FS, files []*manifestFile, concurrency int, spool string) error {
+	p := prefetch(cfs, files, concurrency, prefetchBytes)
+	defer p.Close()
+
+	for i, mf := range files {
+		f, err := p.Next()
 		if err != nil {
 			return err
 		}
 
-		_, err = part.Write(out)
+		h := sha256.New()
+		var w io.Writer = h
+		var sf *os.File
+		if spool != "" {
+			sf, err = os.Create(filepath.Join(spool, strconv.Itoa(i)))
+			if err != nil {
+				f.Close()
+				return err
+			}
+			w = io.MultiWriter(h, sf)
+		}
+
+		mf.Size, err = io.Copy(w, &contextReader{ctx: ctx, r: f})
+		f.Close()
+		if sf != nil {
+			if cerr := sf.Close(); err == nil {
+				err = cerr
+			}
+		}
 		if err != nil {
 			return err
 		}
+		mf.SHA256 = hex.EncodeToString(h.Sum(nil))
+		if sf != nil {
+			mf.src = filepath.Base(sf.Name())
+		}
+	}
 
-		return nil
-	})
-
-	return body, writer, err
+	return nil
 }
 
-func uploadFile(remotefs fs.FS, path string) (*bytes.Buffer, *multipart.Writer, error) {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	defer writer.Close()
+// Streams the upload form with the manifest and the contents of the files
+// in upload. If mirror is true, the server deletes the published files
+// not in the manifest.
+//
+// Files are read while the form is being sent, errors reading them are
+// returned by the reader.
+func (c *Client) uploadFiles(cfs fs.FS, files []*manifestFile, upload map[string]bool, mirror bool) (io.ReadCloser, *multipart.Writer) {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	go func() {
+		err := c.writeForm(writer, cfs, files, upload, mirror)
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, writer
+}
 
-	fmt.Println("Adding ", path)
-	part, err := writer.CreateFormFile("upload[]", path)
+func (c *Client) writeForm(writer *multipart.Writer, cfs fs.FS, files []*manifestFile, upload map[string]bool, mirror bool) error {
+	m, err := json.Marshal(&manifest{Files: files})
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
 
-	f, err := remotefs.Open(path)
+	err = writer.WriteField("manifest", string(m))
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
-	defer f.Close()
 
-	out, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil, nil, err
+	if mirror {
+		err = writer.WriteField("delete", "true")
+		if err != nil {
+			return err
+		}
+	}
+
+	uploads := []*manifestFile{}
+	for _, mf := range files {
+		if upload[mf.Path] {
+			uploads = append(uploads, mf)
+		}
+	}
+
+	// Files are opened ahead of time, but streamed in the manifest order
+	p := prefetch(cfs, uploads, c.config.Concurrency, prefetchBytes)
+	defer p.Close()
+
+	for _, mf := range uploads {
+		f, err := p.Next()
+		if err != nil {
+			return err
+		}
+
+		c.emit(Event{Type: EventUploading, Path: mf.Path, Size: mf.Size})
+		part, err := writer.CreateFormFile("upload[]", mf.Path)
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		_, err = io.Copy(&progressCounter{c: c, mf: mf, w: part}, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		c.emit(Event{Type: EventUploaded, Path: mf.Path, Size: mf.Size})
 	}
 
-	_, err = part.Write(out)
+	return nil
+}
+
+// Returns the token authenticating publish requests, the deploy token if
+// configured or a Charm JWT.
+func (c *Client) publishToken() (string, error) {
+	if c.config.Token != "" {
+		return c.config.Token, nil
+	}
 
-	return body, writer, err
+	return c.jwt()
+}
+
+// Returns a Charm JWT for the Tavern server.
+func (c *Client) jwt() (string, error) {
+	if c.charmClient == nil {
+		return "", ErrCharmAccount
+	}
+
+	return c.charmClient.JWT("tavern")
 }
 
 func charmId(token string) (string, error) {
@@ -227,3 +666,36 @@ func charmId(token string) (string, error) {
 	claims := t.Claims.(*jwt.RegisteredClaims)
 	return claims.Subject, nil
 }
+
+// Returns the URL of a Tavern server API route, for the configured site.
+func (c *Client) url(route string) string {
+	if c.config.Site == "" {
+		return c.config.ServerURL + route
+	}
+
+	return c.config.ServerURL + route + "?site=" + url.QueryEscape(c.config.Site)
+}
+
+// Returns the HTTP client used for the requests to the Tavern server.
+func (c *Client) httpClient() *http.Client {
+	if c.config.HTTPClient != nil {
+		return c.config.HTTPClient
+	}
+
+	return http.DefaultClient
+}
+
+// Reader failing once ctx is done, so reading large files from the source
+// filesystem can be aborted.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/rubiojr/tavern/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishContext(t *testing.T) {
+	// Tavern server not answering until the test ends
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	cfg := DefaultConfig()
+	cfg.ServerURL = ts.URL
+	cfg.CharmServerHost = testutil.CharmServerHost
+	cfg.HTTPClient = ts.Client()
+	c, err := NewClientWithConfig(cfg)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		_, err := c.PublishContext(ctx, LocalScheme+"testdata")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := c.PublishContext(ctx, LocalScheme+"testdata")
+		assert.True(t, errors.Is(err, context.Canceled), err)
+	})
+}
+
+func TestHashFilesSpool(t *testing.T) {
+	fsys := &slowFS{MapFS: fstest.MapFS{
+		"site/index.html": &fstest.MapFile{Data: []byte("index")},
+		"site/about.html": &fstest.MapFile{Data: []byte("about")},
+	}}
+	files := []*manifestFile{
+		{Path: "/index.html", Size: 5, src: "site/index.html"},
+		{Path: "/about.html", Size: 5, src: "site/about.html"},
+	}
+
+	spool := t.TempDir()
+	assert.NoError(t, hashFiles(context.Background(), fsys, files, 2, spool))
+
+	// Files are read from the spool directory once hashed
+	spooled := os.DirFS(spool)
+	for _, mf := range files {
+		data, err := fs.ReadFile(spooled, mf.src)
+		if assert.NoError(t, err) {
+			h := sha256.Sum256(data)
+			assert.Equal(t, hex.EncodeToString(h[:]), mf.SHA256)
+			assert.Equal(t, int64(len(data)), mf.Size)
+		}
+	}
+	data, _ := fs.ReadFile(spooled, files[0].src)
+	assert.Equal(t, "index", string(data))
+}
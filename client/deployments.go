@@ -0,0 +1,124 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/rubiojr/tavern/server"
+)
+
+// Deployment of a published site in a Tavern server.
+type Deployment struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+	Files   int       `json:"files"`
+	Live    bool      `json:"live"`
+}
+
+type deploymentResponse struct {
+	Deployment *Deployment `json:"deployment"`
+}
+
+// Deployments returns the deployment history of the site, newest first.
+func (c *Client) Deployments() ([]*Deployment, error) {
+	return c.DeploymentsContext(context.Background())
+}
+
+// DeploymentsContext is Deployments, aborting when ctx is done.
+func (c *Client) DeploymentsContext(ctx context.Context) ([]*Deployment, error) {
+	resp := &struct {
+		Deployments []*Deployment `json:"deployments"`
+	}{}
+	jwt, err := c.jwt()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.apiRequest(ctx, jwt, http.MethodGet, server.DeploymentsRoute, nil, resp)
+	if err != nil {
+		return nil, fmt.Errorf("listing deployments failed: %w", err)
+	}
+
+	return resp.Deployments, nil
+}
+
+// Rollback makes a previous deployment live again. If id is empty, rolls
+// back to the deployment published before the live one.
+func (c *Client) Rollback(id string) (*Deployment, error) {
+	return c.RollbackContext(context.Background(), id)
+}
+
+// RollbackContext is Rollback, aborting when ctx is done.
+func (c *Client) RollbackContext(ctx context.Context, id string) (*Deployment, error) {
+	body, err := json.Marshal(map[string]string{"id": id})
+	if err != nil {
+		return nil, err
+	}
+
+	jwt, err := c.jwt()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &deploymentResponse{}
+	// Not retried, rolling back to the previous deployment twice would go
+	// two deployments back
+	err = c.apiRequestOnce(ctx, jwt, http.MethodPost, server.RollbackRoute, body, resp)
+	if err != nil {
+		return nil, fmt.Errorf("rollback failed: %w", err)
+	}
+
+	return resp.Deployment, nil
+}
+
+// Sends a request authenticated with token to the Tavern server API and
+// decodes the JSON response into v, retrying it if it fails.
+func (c *Client) apiRequest(ctx context.Context, token, method, route string, body []byte, v interface{}) error {
+	return c.sendAPIRequest(ctx, c.config.Retries, token, method, route, body, v)
+}
+
+// Sends an API request without retrying it, for requests that aren't safe
+// to send twice: the server may have handled a request whose response was
+// lost.
+func (c *Client) apiRequestOnce(ctx context.Context, token, method, route string, body []byte, v interface{}) error {
+	return c.sendAPIRequest(ctx, 0, token, method, route, body, v)
+}
+
+func (c *Client) sendAPIRequest(ctx context.Context, retries int, token, method, route string, body []byte, v interface{}) error {
+	resp, err := c.doRetries(ctx, retries, func() (*http.Request, error) {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.url(route), r)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", fmt.Sprintf("bearer %s", token))
+		if body != nil {
+			req.Header.Add("Content-Type", "application/json")
+		}
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		errStatus, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%s", errStatus)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"io"
+	"time"
+)
+
+// Kind of progress event sent while publishing
+type EventType int
+
+const (
+	// Publishing starts, Path is the path being published
+	EventPublishing EventType = iota
+	// Files are being retrieved from Charm FS, URL is the Charm server host
+	EventRetrieving
+	// Files are being published to the Tavern server in URL
+	EventConnecting
+	// Count files are already published and won't be uploaded
+	EventSkipped
+	// Count files, Size bytes in total, are going to be uploaded
+	EventUploadStarted
+	// The file in Path, of Size bytes, is being uploaded starting at Offset
+	EventUploading
+	// Offset bytes of the file in Path, of Size bytes, have been uploaded
+	EventProgress
+	// The file in Path, of Size bytes, has been uploaded
+	EventUploaded
+	// The file in Path was deleted from the site
+	EventDeleted
+	// A request failed with Err, and will be retried for the Count time
+	// after waiting Wait
+	EventRetrying
+)
+
+// Progress event sent to Config.OnEvent while publishing
+type Event struct {
+	Type   EventType
+	Path   string
+	URL    string
+	Size   int64
+	Offset int64
+	Count  int
+	Wait   time.Duration
+	Err    error
+}
+
+// Result of a publish
+type PublishResult struct {
+	// Public URL of the site
+	URL string `json:"url"`
+	// Deployment created
+	Deployment *Deployment `json:"deployment"`
+	// Files and bytes published, including the unchanged ones
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// Files and bytes uploaded
+	Uploaded      int   `json:"uploaded"`
+	UploadedBytes int64 `json:"uploaded_bytes"`
+	// Files unchanged since the last publish, not uploaded
+	Skipped int `json:"skipped"`
+	// Every file published
+	Published []*PublishedFile `json:"published"`
+	// Files deleted from the site, publishing with Config.Delete
+	Deleted []string `json:"deleted"`
+	// When publishing started, and how long it took to read and hash the
+	// files, to upload them, and in total
+	Started        time.Time     `json:"started"`
+	ScanDuration   time.Duration `json:"scan_duration"`
+	UploadDuration time.Duration `json:"upload_duration"`
+	Duration       time.Duration `json:"duration"`
+}
+
+// File published
+type PublishedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// False if the file was unchanged and wasn't uploaded
+	Uploaded bool `json:"uploaded"`
+}
+
+// Sends an event to the configured callback, if any.
+func (c *Client) emit(e Event) {
+	if c.config.OnEvent != nil {
+		c.config.OnEvent(e)
+	}
+}
+
+// Sends EventProgress events for the bytes of a file written to w or read
+// from r.
+type progressCounter struct {
+	c  *Client
+	mf *manifestFile
+	n  int64
+	w  io.Writer
+	r  io.Reader
+}
+
+func (p *progressCounter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.add(n)
+	return n, err
+}
+
+func (p *progressCounter) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.add(n)
+	return n, err
+}
+
+func (p *progressCounter) add(n int) {
+	if n <= 0 {
+		return
+	}
+
+	p.n += int64(n)
+	p.c.emit(Event{Type: EventProgress, Path: p.mf.Path, Size: p.mf.Size, Offset: p.n})
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Gitignore-style patterns in this file, at the root of the path being
+// published, exclude files from publishing.
+const IgnoreFile = ".tavernignore"
+
+// List of gitignore-style patterns. The last pattern matching a path
+// decides if it's ignored, patterns starting with ! include the paths
+// matched.
+type ignoreList struct {
+	patterns []*ignorePattern
+}
+
+type ignorePattern struct {
+	// Pattern as written, and where it comes from
+	pattern string
+	source  string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+func (p *ignorePattern) String() string {
+	return fmt.Sprintf("%s (%s)", p.pattern, p.source)
+}
+
+// Adds the patterns in an ignore file.
+func (l *ignoreList) parse(data []byte, source string) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		err := l.add(line, source)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Adds a gitignore-style pattern.
+func (l *ignoreList) add(pattern, source string) error {
+	p := &ignorePattern{pattern: pattern, source: source}
+	pat := pattern
+	if strings.HasPrefix(pat, "!") {
+		p.negate = true
+		pat = pat[1:]
+	}
+	pat = strings.TrimPrefix(pat, `\`)
+
+	if strings.HasSuffix(pat, "/") {
+		p.dirOnly = true
+		pat = strings.TrimRight(pat, "/")
+	}
+
+	// Patterns without a slash match at any level, the rest are relative
+	// to the root of the path being published.
+	prefix := "^(.*/)?"
+	if strings.Contains(pat, "/") {
+		prefix = "^"
+		pat = strings.TrimPrefix(pat, "/")
+	}
+
+	if pat == "" {
+		return fmt.Errorf("invalid pattern %q", pattern)
+	}
+
+	re, err := regexp.Compile(prefix + globToRegexp(pat) + "$")
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	p.re = re
+	l.patterns = append(l.patterns, p)
+
+	return nil
+}
+
+// Returns true if path, relative to the root of the path being published,
+// is ignored.
+func (l *ignoreList) ignored(path string, isDir bool) bool {
+	ignored := false
+	for _, p := range l.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(path) {
+			ignored = !p.negate
+		}
+	}
+
+	return ignored
+}
+
+func globToRegexp(glob string) string {
+	var re strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return re.String()
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreList(t *testing.T) {
+	ignore := &ignoreList{}
+	err := ignore.parse([]byte(`
+# comment
+*.swp
+!keep.swp
+drafts/
+/secret.txt
+assets/**/*.map
+`), IgnoreFile)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"index.html", false, false},
+		{"a.swp", false, true},
+		{"dir/b.swp", false, true},
+		{"dir/keep.swp", false, false},
+		{"drafts", true, true},
+		{"blog/drafts", true, true},
+		{"drafts", false, false},
+		{"secret.txt", false, true},
+		{"dir/secret.txt", false, false},
+		{"assets/app.js.map", false, true},
+		{"assets/js/app.js.map", false, true},
+		{"app.js.map", false, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.ignored, ignore.ignored(tt.path, tt.isDir), tt.path)
+	}
+}
+
+func TestWalkFilesIgnore(t *testing.T) {
+	fsys := fstest.MapFS{
+		"site/index.html":        {},
+		"site/.tavernignore":     {Data: []byte("drafts/\n*.map\n")},
+		"site/drafts/post.html":  {},
+		"site/app.js.map":        {},
+		"site/vendor.js.map":     {},
+		"site/tmp/cache.bin":     {},
+		"site/posts/first.html":  {},
+		"site/posts/first.draft": {},
+	}
+
+	c := &Client{config: &Config{
+		Exclude: []string{"tmp/", "*.draft"},
+		Include: []string{"vendor.js.map"},
+	}}
+	ignore, err := c.ignoreList(fsys, "site", true)
+	assert.NoError(t, err)
+
+	files, err := walkFiles(context.Background(), fsys, "site", ignore)
+	assert.NoError(t, err)
+
+	paths := []string{}
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, []string{"/index.html", "/posts/first.html", "/vendor.js.map"}, paths)
+}
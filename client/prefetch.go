@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"io/fs"
+	"sync"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// Files are opened this many at a time by default while publishing
+const DefaultConcurrency = 8
+
+// Bytes of the files opened ahead of time and not closed yet. Files in Charm
+// FS are kept in memory once opened, this bounds the memory used by the
+// prefetched ones.
+const prefetchBytes = 64 << 20
+
+// Opens files from a filesystem ahead of time, with a bounded number of
+// workers and bytes, so files stored remotely like the ones in Charm FS are
+// downloaded in parallel. Files are returned in the order given.
+type prefetcher struct {
+	results []chan *openResult
+	next    int
+	sem     chan struct{}
+	mem     *semaphore.Weighted
+	ctx     context.Context
+	cancel  context.CancelFunc
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+type openResult struct {
+	f   fs.File
+	err error
+}
+
+// File opened ahead of time, releasing its bytes when closed.
+type prefetchedFile struct {
+	fs.File
+	once    sync.Once
+	release func()
+}
+
+func (f *prefetchedFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(f.release)
+	return err
+}
+
+// Starts opening files from fsys, n at a time and up to maxBytes of them
+// until they're closed. Files larger than maxBytes are opened one at a time.
+// The prefetcher must be closed to release the files not returned by Next.
+func prefetch(fsys fs.FS, files []*manifestFile, n int, maxBytes int64) *prefetcher {
+	if n < 1 {
+		n = 1
+	}
+	if maxBytes < 1 {
+		maxBytes = prefetchBytes
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &prefetcher{
+		results: make([]chan *openResult, len(files)),
+		sem:     make(chan struct{}, n),
+		mem:     semaphore.NewWeighted(maxBytes),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for i := range p.results {
+		p.results[i] = make(chan *openResult, 1)
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for i, mf := range files {
+			select {
+			case p.sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			size := mf.Size
+			if size > maxBytes {
+				size = maxBytes
+			}
+			if p.mem.Acquire(ctx, size) != nil {
+				return
+			}
+
+			p.wg.Add(1)
+			go func(res chan *openResult, src string, size int64) {
+				defer p.wg.Done()
+				release := func() { p.mem.Release(size) }
+				f, err := fsys.Open(src)
+				if err != nil {
+					release()
+					res <- &openResult{err: err}
+					return
+				}
+				res <- &openResult{f: &prefetchedFile{File: f, release: release}}
+			}(p.results[i], mf.src, size)
+		}
+	}()
+
+	return p
+}
+
+// Returns the next file, opened. The caller must close it, before asking
+// for the next one.
+func (p *prefetcher) Next() (fs.File, error) {
+	res := <-p.results[p.next]
+	p.next++
+	<-p.sem
+
+	return res.f, res.err
+}
+
+// Stops opening files and closes the ones opened but not returned yet.
+func (p *prefetcher) Close() {
+	p.once.Do(func() {
+		p.cancel()
+		p.wg.Wait()
+		for _, res := range p.results[p.next:] {
+			select {
+			case r := <-res:
+				if r.f != nil {
+					r.f.Close()
+				}
+			default:
+			}
+		}
+	})
+}
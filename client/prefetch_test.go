@@ -0,0 +1,117 @@
+package client
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Filesystem with slow opens, tracking how many are in progress and how
+// many files are open
+type slowFS struct {
+	fstest.MapFS
+	mu      sync.Mutex
+	opening int
+	max     int
+	open    int
+	maxOpen int
+}
+
+type slowFile struct {
+	fs.File
+	fsys *slowFS
+}
+
+func (f *slowFile) Close() error {
+	f.fsys.mu.Lock()
+	f.fsys.open--
+	f.fsys.mu.Unlock()
+	return f.File.Close()
+}
+
+func (s *slowFS) Open(name string) (fs.File, error) {
+	s.mu.Lock()
+	s.opening++
+	if s.opening > s.max {
+		s.max = s.opening
+	}
+	s.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	f, err := s.MapFS.Open(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.opening--
+	if err != nil {
+		return nil, err
+	}
+	s.open++
+	if s.open > s.maxOpen {
+		s.maxOpen = s.open
+	}
+	return &slowFile{File: f, fsys: s}, nil
+}
+
+func TestPrefetch(t *testing.T) {
+	fsys := &slowFS{MapFS: fstest.MapFS{}}
+	files := []*manifestFile{}
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("file%02d", i)
+		fsys.MapFS[name] = &fstest.MapFile{Data: []byte(name)}
+		files = append(files, &manifestFile{Path: "/" + name, Size: int64(len(name)), src: name})
+	}
+
+	p := prefetch(fsys, files, 4, 0)
+	for _, mf := range files {
+		f, err := p.Next()
+		if err != nil {
+			assert.FailNow(t, err.Error())
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, mf.src, string(data))
+	}
+	p.Close()
+
+	assert.LessOrEqual(t, fsys.max, 4)
+	assert.Greater(t, fsys.max, 1)
+
+	t.Run("bounded bytes", func(t *testing.T) {
+		fsys.maxOpen = 0
+		// Room for two of the 6 bytes files
+		p := prefetch(fsys, files, 4, 13)
+		defer p.Close()
+		for range files {
+			f, err := p.Next()
+			if err != nil {
+				assert.FailNow(t, err.Error())
+			}
+			time.Sleep(time.Millisecond)
+			f.Close()
+		}
+		assert.LessOrEqual(t, fsys.maxOpen, 2)
+	})
+
+	t.Run("close before reading all the files", func(t *testing.T) {
+		p := prefetch(fsys, files, 4, 0)
+		f, err := p.Next()
+		assert.NoError(t, err)
+		f.Close()
+		p.Close()
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		p := prefetch(fsys, []*manifestFile{{src: "missing"}}, 4, 0)
+		defer p.Close()
+		_, err := p.Next()
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Requests failing with network errors or 5xx and 429 responses are retried
+// this many times by default
+const DefaultRetries = 3
+
+// Wait before the first retry, doubled for every retry after it
+const DefaultRetryWait = 500 * time.Millisecond
+
+// Longest wait between retries, unless the server asks for more with
+// Retry-After
+const maxRetryWait = 30 * time.Second
+
+// Failed request that can be retried
+type retryError struct {
+	err error
+	// Wait requested by the server with Retry-After, if any
+	wait time.Duration
+}
+
+func (e *retryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryError) Unwrap() error {
+	return e.err
+}
+
+// Sends the request built by newReq, retrying it on network errors and 5xx
+// and 429 responses. newReq is called for every attempt, so the request body
+// can be sent again.
+func (c *Client) do(ctx context.Context, newReq func() (*http.Request, error)) (*http.Response, error) {
+	return c.doRetries(ctx, c.config.Retries, newReq)
+}
+
+// Sends the request built by newReq, retrying it up to retries times.
+func (c *Client) doRetries(ctx context.Context, retries int, newReq func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.httpClient().Do(req)
+		rerr := retryable(ctx, resp, err)
+		if rerr == nil || attempt >= retries {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		err = c.backoff(ctx, attempt, rerr)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Waits before retrying a failed request, for the time requested by the
+// server or with exponential backoff and jitter otherwise.
+func (c *Client) backoff(ctx context.Context, attempt int, rerr *retryError) error {
+	wait := rerr.wait
+	if wait <= 0 {
+		wait = c.config.RetryWait
+		if wait <= 0 {
+			wait = DefaultRetryWait
+		}
+		for i := 0; i < attempt && wait < maxRetryWait; i++ {
+			wait *= 2
+		}
+		if wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	}
+
+	c.emit(Event{Type: EventRetrying, Count: attempt + 1, Wait: wait, Err: rerr.err})
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns a retryError if the request that got resp or err can be retried,
+// nil otherwise.
+func retryable(ctx context.Context, resp *http.Response, err error) *retryError {
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil
+		}
+		return &retryError{err: err}
+	}
+
+	if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	return &retryError{err: errors.New(resp.Status), wait: retryAfter(resp)}
+}
+
+// Returns the wait requested in the Retry-After header of resp, in seconds
+// or as an HTTP date.
+func retryAfter(resp *http.Response) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
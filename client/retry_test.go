@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	cfs "github.com/charmbracelet/charm/fs"
+	"github.com/rubiojr/tavern/internal/testutil"
+	"github.com/rubiojr/tavern/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetries(t *testing.T) {
+	const content = "retried upload"
+	const serverAddr = "127.0.0.1:8003"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tav := server.NewServerWithConfig(&server.Config{Addr: serverAddr, UploadsPath: t.TempDir()})
+	go tav.Serve(ctx)
+	if !testutil.WaitForServer(serverAddr) {
+		assert.FailNow(t, "tavern server did not start")
+	}
+
+	// Proxy failing the first request of every method
+	target, _ := url.Parse("http://" + serverAddr)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	failed := map[string]bool{}
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fail := !failed[r.Method]
+		failed[r.Method] = true
+		mu.Unlock()
+
+		switch {
+		case fail && r.Method == http.MethodPatch:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case fail:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			proxy.ServeHTTP(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := DefaultConfig()
+	cfg.ServerURL = ts.URL
+	cfg.CharmServerHost = testutil.CharmServerHost
+	cfg.ChunkSize = 4
+	cfg.RetryWait = time.Millisecond
+	retries := []Event{}
+	cfg.OnEvent = func(e Event) {
+		if e.Type == EventRetrying {
+			retries = append(retries, e)
+		}
+	}
+	c, err := NewClientWithConfig(cfg)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	charmfs, err := cfs.NewFSWithClient(c.charmClient)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	err = testutil.WriteCharmFile(charmfs, "testdata/retried.txt", content)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	res, err := c.Publish("testdata/retried.txt")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	// Manifest and upload POSTs share the failure, plus HEAD and PATCH
+	assert.Len(t, retries, 3)
+	assert.Equal(t, time.Second, retries[2].Wait)
+
+	id, err := c.charmClient.ID()
+	assert.NoError(t, err)
+	assert.Equal(t, ts.URL+"/"+id, res.URL)
+	resp, err := http.Get("http://" + serverAddr + "/" + id + "/testdata/retried.txt")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(out))
+
+	t.Run("no retries", func(t *testing.T) {
+		cfg.Retries = 0
+		defer func() { cfg.Retries = DefaultRetries }()
+		mu.Lock()
+		failed = map[string]bool{}
+		mu.Unlock()
+
+		_, err := c.Publish("testdata/retried.txt")
+		assert.Error(t, err)
+	})
+
+	t.Run("token creation is not retried", func(t *testing.T) {
+		mu.Lock()
+		failed = map[string]bool{}
+		mu.Unlock()
+		retries = retries[:0]
+
+		_, _, err := c.CreateToken("ci", time.Hour)
+		assert.Error(t, err)
+		assert.Empty(t, retries)
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	assert.Equal(t, time.Duration(0), retryAfter(resp))
+
+	resp.Header.Set("Retry-After", "120")
+	assert.Equal(t, 2*time.Minute, retryAfter(resp))
+
+	resp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, float64(time.Hour), float64(retryAfter(resp)), float64(2*time.Second))
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rubiojr/tavern/server"
+)
+
+// Site is a site name claimed by a Charm account, serving one of its sites
+// at /<name> in the Tavern server.
+type Site struct {
+	Name string `json:"name"`
+	// Path of the site in the server without a name, the Charm ID for
+	// the default site or <charm-id>~<site> for named sites
+	Site    string    `json:"site"`
+	Created time.Time `json:"created"`
+	// Public URL of the site
+	URL string `json:"url"`
+}
+
+type siteResponse struct {
+	Site *Site `json:"site"`
+}
+
+// ClaimSite claims name for the configured site of the Charm account. Names
+// are unique in a Tavern server, and every site can have one.
+func (c *Client) ClaimSite(name string) (*Site, error) {
+	return c.ClaimSiteContext(context.Background(), name)
+}
+
+// ClaimSiteContext is ClaimSite, aborting when ctx is done.
+func (c *Client) ClaimSiteContext(ctx context.Context, name string) (*Site, error) {
+	body, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return nil, err
+	}
+
+	jwt, err := c.jwt()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &siteResponse{}
+	// Not retried, like other requests creating server resources
+	err = c.apiRequestOnce(ctx, jwt, http.MethodPost, server.SitesRoute, body, resp)
+	if err != nil {
+		return nil, fmt.Errorf("claiming site failed: %w", err)
+	}
+
+	return c.siteURL(resp.Site), nil
+}
+
+// Sites returns the site names claimed by the Charm account.
+func (c *Client) Sites() ([]*Site, error) {
+	return c.SitesContext(context.Background())
+}
+
+// SitesContext is Sites, aborting when ctx is done.
+func (c *Client) SitesContext(ctx context.Context) ([]*Site, error) {
+	jwt, err := c.jwt()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &struct {
+		Sites []*Site `json:"sites"`
+	}{}
+	err = c.apiRequest(ctx, jwt, http.MethodGet, server.SitesRoute, nil, resp)
+	if err != nil {
+		return nil, fmt.Errorf("listing sites failed: %w", err)
+	}
+
+	for _, s := range resp.Sites {
+		c.siteURL(s)
+	}
+	return resp.Sites, nil
+}
+
+// ReleaseSite releases a site name claimed by the Charm account, so others
+// can claim it. The site is still served at /<charm-id>.
+func (c *Client) ReleaseSite(name string) (*Site, error) {
+	return c.ReleaseSiteContext(context.Background(), name)
+}
+
+// ReleaseSiteContext is ReleaseSite, aborting when ctx is done.
+func (c *Client) ReleaseSiteContext(ctx context.Context, name string) (*Site, error) {
+	jwt, err := c.jwt()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &siteResponse{}
+	// Not retried, a retry after a lost response would fail as not found
+	err = c.apiRequestOnce(ctx, jwt, http.MethodDelete, server.SitesRoute+"/"+url.PathEscape(name), nil, resp)
+	if err != nil {
+		return nil, fmt.Errorf("releasing site failed: %w", err)
+	}
+
+	return c.siteURL(resp.Site), nil
+}
+
+func (c *Client) siteURL(s *Site) *Site {
+	if s != nil {
+		s.URL = c.config.ServerURL + "/" + s.Name
+	}
+
+	return s
+}
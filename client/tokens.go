@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rubiojr/tavern/server"
+)
+
+// Token is a deploy token, publishing to a site without a Charm account.
+type Token struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Site    string    `json:"site"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+type tokenResponse struct {
+	Token  *Token `json:"token"`
+	Secret string `json:"secret"`
+}
+
+// CreateToken issues a deploy token publishing to the site of the Charm
+// account, valid for ttl. Returns the token and its secret, that the server
+// won't return again.
+func (c *Client) CreateToken(name string, ttl time.Duration) (*Token, string, error) {
+	return c.CreateTokenContext(context.Background(), name, ttl)
+}
+
+// CreateTokenContext is CreateToken, aborting when ctx is done.
+func (c *Client) CreateTokenContext(ctx context.Context, name string, ttl time.Duration) (*Token, string, error) {
+	body, err := json.Marshal(map[string]interface{}{"name": name, "expires": time.Now().Add(ttl)})
+	if err != nil {
+		return nil, "", err
+	}
+
+	jwt, err := c.jwt()
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp := &tokenResponse{}
+	// Retrying could create tokens whose secret is never seen
+	err = c.apiRequestOnce(ctx, jwt, http.MethodPost, server.TokensRoute, body, resp)
+	if err != nil {
+		return nil, "", fmt.Errorf("creating token failed: %w", err)
+	}
+
+	return resp.Token, resp.Secret, nil
+}
+
+// Tokens returns the deploy tokens of the Charm account, oldest first.
+func (c *Client) Tokens() ([]*Token, error) {
+	return c.TokensContext(context.Background())
+}
+
+// TokensContext is Tokens, aborting when ctx is done.
+func (c *Client) TokensContext(ctx context.Context) ([]*Token, error) {
+	jwt, err := c.jwt()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &struct {
+		Tokens []*Token `json:"tokens"`
+	}{}
+	err = c.apiRequest(ctx, jwt, http.MethodGet, server.TokensRoute, nil, resp)
+	if err != nil {
+		return nil, fmt.Errorf("listing tokens failed: %w", err)
+	}
+
+	return resp.Tokens, nil
+}
+
+// RevokeToken revokes the deploy token with the given ID.
+func (c *Client) RevokeToken(id string) (*Token, error) {
+	return c.RevokeTokenContext(context.Background(), id)
+}
+
+// RevokeTokenContext is RevokeToken, aborting when ctx is done.
+func (c *Client) RevokeTokenContext(ctx context.Context, id string) (*Token, error) {
+	jwt, err := c.jwt()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &tokenResponse{}
+	// Not retried, a retry after a lost response would fail as not found
+	err = c.apiRequestOnce(ctx, jwt, http.MethodDelete, server.TokensRoute+"/"+url.PathEscape(id), nil, resp)
+	if err != nil {
+		return nil, fmt.Errorf("revoking token failed: %w", err)
+	}
+
+	return resp.Token, nil
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/rubiojr/tavern/client"
+	"github.com/spf13/cobra"
+)
+
+// Flags shared by the commands talking to a Tavern server
+var serverURL, charmHost, profileName string
+var charmHTTPPort, charmSSHPort int
+var timeout time.Duration
+var siteName string
+
+const defaultURL = "https://pub.rbel.co"
+
+func addClientFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&serverURL, "server-url", "s", defaultURL, "Tavern server URL")
+	cmd.Flags().StringVarP(&charmHost, "charm-server-host", "", "cloud.charm.sh", "Charm server URL")
+	cmd.Flags().IntVarP(&charmHTTPPort, "charm-server-http-port", "", 35354, "Charm server URL")
+	cmd.Flags().IntVarP(&charmSSHPort, "charm-server-ssh-port", "", 35353, "Charm server URL")
+	cmd.Flags().StringVarP(&profileName, "profile", "", "", "Configuration file profile to use (defaults to TAVERN_PROFILE or the default profile)")
+	cmd.Flags().DurationVarP(&timeout, "timeout", "", 0, "Abort if the command takes longer than this (e.g. 5m, no timeout by default)")
+}
+
+// Adds the --site flag to commands acting on a site of the account.
+func addSiteFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&siteName, "site", "", "", "Site of your account to act on, like blog (the default site if not set)")
+}
+
+// Returns a context cancelled on Ctrl-C or SIGTERM, or when the --timeout
+// flag expires.
+func clientContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+func newClient(cmd *cobra.Command) (*client.Client, error) {
+	cfg, err := clientConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.NewClientWithConfig(cfg)
+}
+
+// Returns the client configuration. Flags take precedence over environment
+// variables, environment variables over the configuration file profile, and
+// the profile over the defaults.
+func clientConfig(cmd *cobra.Command) (*client.Config, error) {
+	cfg := client.DefaultConfig()
+	cfg.ServerURL = defaultURL
+	// Left to the Charm client, that defaults to cloud.charm.sh and reads
+	// CHARM_HOST, CHARM_HTTP_PORT and CHARM_SSH_PORT
+	cfg.CharmServerHost = ""
+	cfg.CharmServerHTTPPort = 0
+	cfg.CharmServerSSHPort = 0
+
+	name := profileName
+	if !cmd.Flags().Changed("profile") {
+		name = os.Getenv("TAVERN_PROFILE")
+	}
+	p, err := loadProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		if p.ServerURL != "" {
+			cfg.ServerURL = p.ServerURL
+		}
+		if p.CharmServerHost != "" {
+			cfg.CharmServerHost = p.CharmServerHost
+		}
+		if p.CharmServerHTTPPort != 0 {
+			cfg.CharmServerHTTPPort = p.CharmServerHTTPPort
+		}
+		if p.CharmServerSSHPort != 0 {
+			cfg.CharmServerSSHPort = p.CharmServerSSHPort
+		}
+	}
+
+	if v := os.Getenv("TAVERN_SERVER_URL"); v != "" {
+		cfg.ServerURL = v
+	}
+	if v := os.Getenv("CHARM_HOST"); v != "" {
+		cfg.CharmServerHost = v
+	}
+	for env, port := range map[string]*int{"CHARM_HTTP_PORT": &cfg.CharmServerHTTPPort, "CHARM_SSH_PORT": &cfg.CharmServerSSHPort} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", env, err)
+			}
+			*port = n
+		}
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("server-url") {
+		cfg.ServerURL = serverURL
+	}
+	if flags.Changed("charm-server-host") {
+		cfg.CharmServerHost = charmHost
+	}
+	if flags.Changed("charm-server-http-port") {
+		cfg.CharmServerHTTPPort = charmHTTPPort
+	}
+	if flags.Changed("charm-server-ssh-port") {
+		cfg.CharmServerSSHPort = charmSSHPort
+	}
+	if flags.Changed("site") {
+		cfg.Site = siteName
+	}
+
+	return cfg, nil
+}
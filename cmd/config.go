@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Profile used when --profile and TAVERN_PROFILE aren't set, if the
+// configuration file has it
+const defaultProfile = "default"
+
+// Client configuration file, with named profiles:
+//
+//	[profiles.default]
+//	server_url = "https://pub.rbel.co"
+//
+//	[profiles.work]
+//	server_url = "https://tavern.example.com"
+//	charm_server_host = "charm.example.com"
+type configFile struct {
+	Profiles map[string]*profile `toml:"profiles"`
+}
+
+// Client settings of a profile, empty settings are left to the defaults
+type profile struct {
+	ServerURL           string `toml:"server_url"`
+	CharmServerHost     string `toml:"charm_server_host"`
+	CharmServerHTTPPort int    `toml:"charm_server_http_port"`
+	CharmServerSSHPort  int    `toml:"charm_server_ssh_port"`
+}
+
+// Returns the path of the configuration file, set with TAVERN_CONFIG or
+// config.toml under $XDG_CONFIG_HOME/tavern (~/.config/tavern by default).
+func configPath() (string, error) {
+	if p := os.Getenv("TAVERN_CONFIG"); p != "" {
+		return p, nil
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "tavern", "config.toml"), nil
+}
+
+// Returns the profile named name from the configuration file. If name is
+// empty, returns the default profile if there's one, nil otherwise.
+func loadProfile(name string) (*profile, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && name == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the configuration file: %w", err)
+	}
+
+	cfg := &configFile{}
+	err = toml.Unmarshal(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+
+	if name == "" {
+		return cfg.Profiles[defaultProfile], nil
+	}
+
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q not found in %s", name, path)
+	}
+
+	return p, nil
+}
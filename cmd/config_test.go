@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := ioutil.WriteFile(path, []byte(`
+[profiles.default]
+server_url = "https://default.example.com"
+
+[profiles.work]
+server_url = "https://work.example.com"
+charm_server_host = "charm.work.example.com"
+charm_server_ssh_port = 2222
+`), 0600)
+	assert.NoError(t, err)
+
+	t.Setenv("TAVERN_CONFIG", path)
+	for _, env := range []string{"TAVERN_PROFILE", "TAVERN_SERVER_URL", "CHARM_HOST", "CHARM_HTTP_PORT", "CHARM_SSH_PORT"} {
+		t.Setenv(env, "")
+	}
+
+	config := func(args ...string) (string, string, int, error) {
+		cmd := &cobra.Command{}
+		addClientFlags(cmd)
+		assert.NoError(t, cmd.ParseFlags(args))
+		cfg, err := clientConfig(cmd)
+		if err != nil {
+			return "", "", 0, err
+		}
+		return cfg.ServerURL, cfg.CharmServerHost, cfg.CharmServerSSHPort, nil
+	}
+
+	url, host, port, err := config()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://default.example.com", url)
+	assert.Equal(t, "", host)
+	assert.Equal(t, 0, port)
+
+	url, host, port, err = config("--profile", "work")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://work.example.com", url)
+	assert.Equal(t, "charm.work.example.com", host)
+	assert.Equal(t, 2222, port)
+
+	// Environment variables override the profile
+	t.Setenv("TAVERN_PROFILE", "work")
+	t.Setenv("TAVERN_SERVER_URL", "https://env.example.com")
+	url, host, _, err = config()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://env.example.com", url)
+	assert.Equal(t, "charm.work.example.com", host)
+
+	// and flags override everything
+	url, host, _, err = config("--server-url", "https://flag.example.com", "--charm-server-host", "charm.flag.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://flag.example.com", url)
+	assert.Equal(t, "charm.flag.example.com", host)
+
+	_, _, _, err = config("--profile", "missing")
+	assert.EqualError(t, err, `profile "missing" not found in `+path)
+
+	// No configuration file
+	t.Setenv("TAVERN_CONFIG", filepath.Join(t.TempDir(), "missing.toml"))
+	t.Setenv("TAVERN_PROFILE", "")
+	t.Setenv("TAVERN_SERVER_URL", "")
+	url, _, _, err = config()
+	assert.NoError(t, err)
+	assert.Equal(t, defaultURL, url)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/rubiojr/tavern/client"
+	"github.com/spf13/cobra"
+)
+
+type deploymentsOutput struct {
+	Deployments []*client.Deployment `json:"deployments"`
+	ExitStatus  int                  `json:"exit_status"`
+}
+
+var deploymentsCmd = &cobra.Command{
+	Use:   "deployments",
+	Short: "List the deployments of your site",
+	Args:  cobra.NoArgs,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pc, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := clientContext(cmd)
+		defer cancel()
+
+		deployments, err := pc.DeploymentsContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput() {
+			return printJSON(cmd.OutOrStdout(), &deploymentsOutput{Deployments: deployments})
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCREATED\tFILES\tSIZE\tLIVE")
+		for _, d := range deployments {
+			live := ""
+			if d.Live {
+				live = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", d.ID, d.Created.Local().Format("2006-01-02 15:04:05"), d.Files, humanize.Bytes(uint64(d.Size)), live)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deploymentsCmd)
+	addClientFlags(deploymentsCmd)
+	addSiteFlag(deploymentsCmd)
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+)
+
+// Output formats, set with the global --output flag
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+var outputFormat string
+
+// Validates the --output flag. JSON output replaces the error messages and
+// usage printed by cobra, so the output is always a JSON document.
+func checkOutput(cmd *cobra.Command, args []string) error {
+	switch outputFormat {
+	case outputText, "table":
+		outputFormat = outputText
+	case outputJSON:
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+	default:
+		return fmt.Errorf("invalid output format %q", outputFormat)
+	}
+
+	return nil
+}
+
+func jsonOutput() bool {
+	return outputFormat == outputJSON
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// JSON document printed when a command fails
+type errorOutput struct {
+	Error      string `json:"error"`
+	ExitStatus int    `json:"exit_status"`
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/progress"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/dustin/go-humanize"
+	"github.com/mattn/go-isatty"
+	"github.com/rubiojr/tavern/client"
+)
+
+// Displays the progress of a publish, fed by the client events
+type publishDisplay interface {
+	event(e client.Event)
+	close()
+}
+
+// Returns a progress bar display if out is a terminal, a display printing
+// plain lines otherwise.
+func newPublishDisplay(out io.Writer) publishDisplay {
+	if f, ok := out.(*os.File); ok && (isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())) {
+		return newProgressDisplay(out)
+	}
+
+	return &lineDisplay{out: out}
+}
+
+// Returns the line describing e, or an empty string for events not worth a
+// line.
+func eventLine(e client.Event) string {
+	switch e.Type {
+	case client.EventPublishing:
+		return fmt.Sprintf("Publishing %s", e.Path)
+	case client.EventRetrieving:
+		return fmt.Sprintf("Retrieving files from %s...", e.URL)
+	case client.EventConnecting:
+		return fmt.Sprintf("Publishing to %s", e.URL)
+	case client.EventSkipped:
+		return fmt.Sprintf("Skipping %d unchanged files", e.Count)
+	case client.EventUploading:
+		if e.Offset > 0 {
+			return fmt.Sprintf("Adding  %s (resuming at %d bytes)", e.Path, e.Offset)
+		}
+		return fmt.Sprintf("Adding  %s", e.Path)
+	case client.EventDeleted:
+		return fmt.Sprintf("Deleted  %s", e.Path)
+	case client.EventRetrying:
+		return fmt.Sprintf("Request failed (%s), retrying in %s", e.Err, e.Wait.Round(time.Millisecond))
+	}
+
+	return ""
+}
+
+type lineDisplay struct {
+	out io.Writer
+}
+
+func (d *lineDisplay) event(e client.Event) {
+	if line := eventLine(e); line != "" {
+		fmt.Fprintln(d.out, line)
+	}
+}
+
+func (d *lineDisplay) close() {}
+
+// Progress bar with the files and bytes uploaded, throughput and ETA
+type progressDisplay struct {
+	events   chan client.Event
+	finished chan struct{}
+}
+
+func newProgressDisplay(out io.Writer) *progressDisplay {
+	d := &progressDisplay{events: make(chan client.Event), finished: make(chan struct{})}
+	m := &progressModel{bar: progress.New(progress.WithDefaultGradient()), events: d.events, done: map[string]bool{}}
+	// Without reading the terminal input, Ctrl-C still interrupts the publish
+	p := tea.NewProgram(m, tea.WithOutput(out), tea.WithInput(&bytes.Buffer{}))
+	go func() {
+		defer close(d.finished)
+		if err := p.Start(); err != nil {
+			fmt.Fprintln(os.Stderr, "progress display failed:", err)
+		}
+	}()
+
+	return d
+}
+
+func (d *progressDisplay) event(e client.Event) {
+	select {
+	case d.events <- e:
+	case <-d.finished:
+	}
+}
+
+// Stops the display, once it has rendered the last event.
+func (d *progressDisplay) close() {
+	close(d.events)
+	<-d.finished
+}
+
+type eventMsg client.Event
+
+type eventsDoneMsg struct{}
+
+type progressModel struct {
+	bar    progress.Model
+	events chan client.Event
+	// Files and bytes to upload, and uploaded
+	files, totalFiles int
+	bytes, totalBytes int64
+	// Files uploaded, retried uploads send them again
+	done map[string]bool
+	// File being uploaded, and bytes uploaded of it
+	current      string
+	currentBytes int64
+	started      time.Time
+}
+
+func (m *progressModel) Init() tea.Cmd {
+	return m.waitForEvent
+}
+
+func (m *progressModel) waitForEvent() tea.Msg {
+	e, ok := <-m.events
+	if !ok {
+		return eventsDoneMsg{}
+	}
+
+	return eventMsg(e)
+}
+
+func (m *progressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case eventsDoneMsg:
+		m.current = ""
+		return m, tea.Quit
+	case tea.WindowSizeMsg:
+		if msg.Width > 20 {
+			m.bar.Width = msg.Width - 4
+		}
+		if m.bar.Width > 80 {
+			m.bar.Width = 80
+		}
+		return m, nil
+	case eventMsg:
+		e := client.Event(msg)
+		switch e.Type {
+		case client.EventUploadStarted:
+			m.totalFiles, m.totalBytes = e.Count, e.Size
+			m.started = time.Now()
+		case client.EventUploading:
+			m.current, m.currentBytes = e.Path, e.Offset
+		case client.EventProgress:
+			m.currentBytes = e.Offset
+		case client.EventUploaded:
+			if !m.done[e.Path] {
+				m.done[e.Path] = true
+				m.files++
+				m.bytes += e.Size
+			}
+			m.current, m.currentBytes = "", 0
+		}
+
+		// Uploads are shown by the progress bar, other events are printed
+		// above it
+		if line := eventLine(e); line != "" && e.Type != client.EventUploading {
+			return m, tea.Batch(tea.Println(line), m.waitForEvent)
+		}
+		return m, m.waitForEvent
+	}
+
+	return m, nil
+}
+
+func (m *progressModel) View() string {
+	if m.totalFiles == 0 {
+		return ""
+	}
+
+	uploaded := m.bytes + m.currentBytes
+	percent := 1.0
+	if m.totalBytes > 0 {
+		percent = float64(uploaded) / float64(m.totalBytes)
+	}
+	if percent > 1 {
+		percent = 1
+	}
+
+	status := fmt.Sprintf("%d/%d files  %s/%s", m.files, m.totalFiles, humanize.Bytes(uint64(uploaded)), humanize.Bytes(uint64(m.totalBytes)))
+	if elapsed := time.Since(m.started).Seconds(); elapsed >= 1 && uploaded > 0 {
+		rate := float64(uploaded) / elapsed
+		eta := time.Duration(float64(m.totalBytes-uploaded)/rate) * time.Second
+		if eta < 0 {
+			eta = 0
+		}
+		status += fmt.Sprintf("  %s/s  ETA %s", humanize.Bytes(uint64(rate)), eta.Round(time.Second))
+	}
+
+	var b strings.Builder
+	b.WriteString(m.bar.ViewAs(percent) + "\n")
+	b.WriteString(status + "\n")
+	if m.current != "" {
+		b.WriteString(m.current + "\n")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/rubiojr/tavern/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressModel(t *testing.T) {
+	m := &progressModel{bar: progress.New(), done: map[string]bool{}}
+	assert.Equal(t, "", m.View())
+
+	for _, e := range []client.Event{
+		{Type: client.EventUploadStarted, Count: 2, Size: 3000},
+		{Type: client.EventUploading, Path: "/a.txt", Size: 1000},
+		{Type: client.EventProgress, Path: "/a.txt", Size: 1000, Offset: 1000},
+		{Type: client.EventUploaded, Path: "/a.txt", Size: 1000},
+		{Type: client.EventUploading, Path: "/b.txt", Size: 2000},
+		{Type: client.EventProgress, Path: "/b.txt", Size: 2000, Offset: 500},
+	} {
+		m.Update(eventMsg(e))
+	}
+
+	view := m.View()
+	assert.Contains(t, view, "1/2 files  1.5 kB/3.0 kB")
+	assert.Contains(t, view, "/b.txt")
+	assert.Contains(t, view, "50%")
+
+	// Files sent again by a retried upload aren't counted twice
+	m.Update(eventMsg{Type: client.EventUploaded, Path: "/a.txt", Size: 1000})
+	assert.Contains(t, m.View(), "1/2 files")
+}
+
+func TestLineDisplay(t *testing.T) {
+	out := &bytes.Buffer{}
+	d := newPublishDisplay(out)
+	d.event(client.Event{Type: client.EventUploading, Path: "/a.txt"})
+	d.event(client.Event{Type: client.EventProgress, Path: "/a.txt", Offset: 10})
+	d.event(client.Event{Type: client.EventDeleted, Path: "/b.txt"})
+	d.close()
+
+	assert.Equal(t, "Adding  /a.txt\nDeleted  /b.txt\n", out.String())
+}
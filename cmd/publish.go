@@ -1,44 +1,145 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/dustin/go-humanize"
 	"github.com/rubiojr/tavern/client"
 	"github.com/spf13/cobra"
 )
 
-var url, charmHost *string
-var charmHTTPPort, charmSSHPort *int
+var deleteFiles, local, dryRun *bool
+var excludes, includes *[]string
+var retries, concurrency *int
+var token *string
 
-const defaultURL = "https://pub.rbel.co"
+type publishOutput struct {
+	*client.PublishResult
+	ExitStatus int `json:"exit_status"`
+}
+
+type dryRunOutput struct {
+	*client.DryRun
+	ExitStatus int `json:"exit_status"`
+}
 
 var publishCmd = &cobra.Command{
 	Use:   "publish",
-	Short: "Publish Charm FS files to a Tavern server",
+	Short: "Publish Charm FS or local files to a Tavern server",
 	Args:  cobra.ExactArgs(1),
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if *url == defaultURL && os.Getenv("TAVERN_SERVER_URL") != "" {
-			*url = os.Getenv("TAVERN_SERVER_URL")
+		cfg, err := clientConfig(cmd)
+		if err != nil {
+			return err
+		}
+		cfg.Delete = *deleteFiles
+		cfg.Exclude = *excludes
+		cfg.Include = *includes
+		cfg.Retries = *retries
+		cfg.Concurrency = *concurrency
+		cfg.Token = os.Getenv("TAVERN_TOKEN")
+		if cmd.Flags().Changed("token") {
+			cfg.Token = *token
 		}
-
-		cfg := client.DefaultConfig()
-		cfg.ServerURL = *url
-		cfg.CharmServerHost = *charmHost
-		cfg.CharmServerHTTPPort = *charmHTTPPort
 		pc, err := client.NewClientWithConfig(cfg)
 		if err != nil {
 			return err
 		}
 
-		return pc.Publish(args[0])
+		ctx, cancel := clientContext(cmd)
+		defer cancel()
+
+		path := args[0]
+		if *local && !strings.HasPrefix(path, client.LocalScheme) {
+			path = client.LocalScheme + path
+		}
+
+		if *dryRun {
+			return printDryRun(ctx, cmd, pc, path)
+		}
+
+		// The JSON document is printed at the end, progress goes to stderr
+		var display publishDisplay
+		if jsonOutput() {
+			display = &lineDisplay{out: cmd.ErrOrStderr()}
+		} else {
+			display = newPublishDisplay(cmd.OutOrStdout())
+		}
+		cfg.OnEvent = display.event
+		res, err := pc.PublishContext(ctx, path)
+		display.close()
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput() {
+			return printJSON(cmd.OutOrStdout(), &publishOutput{PublishResult: res})
+		}
+
+		fmt.Fprintln(cmd.OutOrStdout(), "Site published!")
+		fmt.Fprintf(cmd.OutOrStdout(), "Visit %s\n", res.URL)
+		return nil
 	},
 }
 
+// Lists the files that would be published, without publishing them.
+func printDryRun(ctx context.Context, cmd *cobra.Command, pc *client.Client, path string) error {
+	dr, err := pc.DryRunContext(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput() {
+		return printJSON(cmd.OutOrStdout(), &dryRunOutput{DryRun: dr})
+	}
+
+	out := cmd.OutOrStdout()
+	for _, p := range dr.Patterns {
+		fmt.Fprintf(out, "Excluding %s\n", p)
+	}
+
+	// Dry runs with a deploy token don't know the URL of the site
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	if dr.URL == "" {
+		fmt.Fprintln(w, "PATH\tSIZE")
+	} else {
+		fmt.Fprintln(w, "URL\tSIZE")
+	}
+	for _, f := range dr.Files {
+		loc := f.URL
+		if loc == "" {
+			loc = f.Path
+		}
+		fmt.Fprintf(w, "%s\t%s\n", loc, humanize.Bytes(uint64(f.Size)))
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if dr.URL == "" {
+		fmt.Fprintf(out, "%d files (%s) would be published to the site of the deploy token\n", len(dr.Files), humanize.Bytes(uint64(dr.Size)))
+		return nil
+	}
+	fmt.Fprintf(out, "%d files (%s) would be published to %s\n", len(dr.Files), humanize.Bytes(uint64(dr.Size)), dr.URL)
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(publishCmd)
-	url = publishCmd.Flags().StringP("server-url", "s", defaultURL, "Tavern server URL")
-	charmHost = publishCmd.Flags().StringP("charm-server-host", "", "cloud.charm.sh", "Charm server URL")
-	charmHTTPPort = publishCmd.Flags().IntP("charm-server-http-port", "", 35354, "Charm server URL")
-	charmSSHPort = publishCmd.Flags().IntP("charm-server-ssh-port", "", 35353, "Charm server URL")
+	addClientFlags(publishCmd)
+	addSiteFlag(publishCmd)
+	local = publishCmd.Flags().BoolP("local", "l", false, "Publish files from the local filesystem instead of Charm FS")
+	deleteFiles = publishCmd.Flags().BoolP("delete", "", false, "Delete published files not present in the path being published")
+	dryRun = publishCmd.Flags().BoolP("dry-run", "n", false, "List the files that would be published, without publishing them")
+	retries = publishCmd.Flags().IntP("retries", "", client.DefaultRetries, "Times failed requests are retried")
+	concurrency = publishCmd.Flags().IntP("concurrency", "", client.DefaultConcurrency, "Files downloaded from Charm FS at a time")
+	excludes = publishCmd.Flags().StringArrayP("exclude", "", []string{}, "Exclude files matching this gitignore-style pattern (repeatable)")
+	token = publishCmd.Flags().StringP("token", "", "", "Deploy token to publish local files with, instead of a Charm account (defaults to TAVERN_TOKEN)")
+	includes = publishCmd.Flags().StringArrayP("include", "", []string{}, "Include files matching this gitignore-style pattern, even if excluded (repeatable)")
 }
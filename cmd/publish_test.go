@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -51,14 +54,152 @@ func TestPublish(t *testing.T) {
 		})
 		_, err = rootCmd.ExecuteC()
 		assert.NoError(t, err)
-		dfile := filepath.Join(tdir, testutil.UploadsPath, cid, "testdata/test.txt")
-		assert.FileExists(t, dfile)
+		// Published files are stored in a deployment that's made live
+		assert.FileExists(t, filepath.Join(tdir, testutil.UploadsPath, cid, "live"))
+		assert.NoFileExists(t, filepath.Join(tdir, testutil.UploadsPath, cid, "testdata/test.txt"))
 
-		out, err := ioutil.ReadFile(dfile)
+		resp, err := http.Get(testutil.TestServerURL + "/" + cid + "/testdata/test.txt")
 		assert.NoError(t, err)
+		defer resp.Body.Close()
+		out, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "foo", strings.TrimRight(string(out), "\r\n"))
+	})
+
+	t.Run("publish to S3 storage", func(t *testing.T) {
+		s3 := testutil.NewFakeS3()
+		defer s3.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, err = testutil.TavernServerS3(ctx, s3.URL, "tavern")
+		assert.NoError(t, err)
+
+		rootCmd.SetArgs([]string{
+			"publish",
+			"--charm-server-host", testutil.CharmServerHost,
+			"--server-url", testutil.TestServerURL,
+			"testdata/test.txt",
+		})
+		_, err = rootCmd.ExecuteC()
+		assert.NoError(t, err)
+		assert.Contains(t, s3.Keys("tavern"), cid+"/live")
+
+		resp, err := http.Get(testutil.TestServerURL + "/" + cid + "/testdata/test.txt")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		out, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
 		assert.Equal(t, "foo", strings.TrimRight(string(out), "\r\n"))
 	})
 
+	t.Run("publish with --delete", func(t *testing.T) {
+		tdir := t.TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		defer func() { *deleteFiles = false }()
+
+		_, err = testutil.TavernServer(ctx, tdir)
+		assert.NoError(t, err)
+
+		err = testutil.WriteCharmFile(charmfs, "testdata/mirror.txt", "mirror")
+		assert.NoError(t, err)
+		for _, args := range [][]string{{"testdata/test.txt"}, {"--delete", "testdata/mirror.txt"}} {
+			rootCmd.SetArgs(append([]string{
+				"publish",
+				"--charm-server-host", testutil.CharmServerHost,
+				"--server-url", testutil.TestServerURL,
+			}, args...))
+			_, err = rootCmd.ExecuteC()
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, "mirror", getPublished(t, cid+"/testdata/mirror.txt"))
+		resp, err := http.Get(testutil.TestServerURL + "/" + cid + "/testdata/test.txt")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 404, resp.StatusCode)
+	})
+
+	t.Run("publish a local directory", func(t *testing.T) {
+		tdir := t.TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		defer func() { *local = false }()
+
+		_, err = testutil.TavernServer(ctx, tdir)
+		assert.NoError(t, err)
+
+		rootCmd.SetArgs([]string{
+			"publish",
+			"--charm-server-host", testutil.CharmServerHost,
+			"--server-url", testutil.TestServerURL,
+			"--local",
+			"testdata",
+		})
+		_, err = rootCmd.ExecuteC()
+		assert.NoError(t, err)
+		assert.Equal(t, "foo", getPublished(t, cid+"/test.txt"))
+	})
+
+	t.Run("publish --dry-run", func(t *testing.T) {
+		defer func() { *local, *dryRun = false, false }()
+		out := &bytes.Buffer{}
+		rootCmd.SetOut(out)
+		defer rootCmd.SetOut(nil)
+
+		// No Tavern server is running, a dry run doesn't need it
+		rootCmd.SetArgs([]string{
+			"publish",
+			"--charm-server-host", testutil.CharmServerHost,
+			"--server-url", testutil.TestServerURL,
+			"--local",
+			"--dry-run",
+			"testdata",
+		})
+		_, err = rootCmd.ExecuteC()
+		assert.NoError(t, err)
+		assert.Contains(t, out.String(), testutil.TestServerURL+"/"+cid+"/test.txt")
+		assert.Contains(t, out.String(), "would be published to "+testutil.TestServerURL+"/"+cid)
+	})
+
+	t.Run("publish --output json", func(t *testing.T) {
+		tdir := t.TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		defer func() { outputFormat = outputText }()
+		out := &bytes.Buffer{}
+		rootCmd.SetOut(out)
+		defer rootCmd.SetOut(nil)
+
+		_, err = testutil.TavernServer(ctx, tdir)
+		assert.NoError(t, err)
+
+		rootCmd.SetArgs([]string{
+			"publish",
+			"--charm-server-host", testutil.CharmServerHost,
+			"--server-url", testutil.TestServerURL,
+			"--output", "json",
+			"testdata/test.txt",
+		})
+		_, err = rootCmd.ExecuteC()
+		assert.NoError(t, err)
+
+		res := &publishOutput{}
+		if !assert.NoError(t, json.Unmarshal(out.Bytes(), res)) {
+			return
+		}
+		assert.Equal(t, 0, res.ExitStatus)
+		assert.Equal(t, testutil.TestServerURL+"/"+cid, res.URL)
+		assert.NotEmpty(t, res.Deployment.ID)
+		if assert.Len(t, res.Published, 1) {
+			assert.Equal(t, "testdata/test.txt", res.Published[0].Path)
+			assert.True(t, res.Published[0].Uploaded)
+		}
+	})
+
 	t.Run("publishing not allowed", func(t *testing.T) {
 		tdir := t.TempDir()
 		ctx, cancel := context.WithCancel(context.Background())
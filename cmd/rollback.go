@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/rubiojr/tavern/client"
+	"github.com/spf13/cobra"
+)
+
+type rollbackOutput struct {
+	Deployment *client.Deployment `json:"deployment"`
+	ExitStatus int                `json:"exit_status"`
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [deployment-id]",
+	Short: "Make a previous deployment live again",
+	Long:  "Make a previous deployment live again. Rolls back to the deployment published before the live one if no deployment ID is given.",
+	Args:  cobra.MaximumNArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pc, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		id := ""
+		if len(args) > 0 {
+			id = args[0]
+		}
+
+		ctx, cancel := clientContext(cmd)
+		defer cancel()
+
+		d, err := pc.RollbackContext(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput() {
+			return printJSON(cmd.OutOrStdout(), &rollbackOutput{Deployment: d})
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Deployment %s is live (%d files, published %s)\n", d.ID, d.Files, d.Created.Local().Format("2006-01-02 15:04:05"))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+	addClientFlags(rollbackCmd)
+	addSiteFlag(rollbackCmd)
+}
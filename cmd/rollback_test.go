@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	cfs "github.com/charmbracelet/charm/fs"
+	"github.com/rubiojr/tavern/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollback(t *testing.T) {
+	cc, err := testutil.CharmClient()
+	if err != nil {
+		assert.FailNow(t, "error starting charm client", err)
+	}
+
+	cid, err := cc.ID()
+	if err != nil {
+		assert.FailNow(t, "error retrieving charm ID", err)
+	}
+
+	charmfs, err := cfs.NewFSWithClient(cc)
+	if err != nil {
+		assert.FailNow(t, "error creating charmfs client", err)
+	}
+
+	tdir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = testutil.TavernServer(ctx, tdir)
+	assert.NoError(t, err)
+
+	for _, content := range []string{"first", "second"} {
+		err = testutil.WriteCharmFile(charmfs, "testdata/rollback.txt", content)
+		assert.NoError(t, err)
+		rootCmd.SetArgs([]string{
+			"publish",
+			"--charm-server-host", testutil.CharmServerHost,
+			"--server-url", testutil.TestServerURL,
+			"testdata/rollback.txt",
+		})
+		_, err = rootCmd.ExecuteC()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "second", getPublished(t, cid+"/testdata/rollback.txt"))
+
+	rootCmd.SetArgs([]string{
+		"rollback",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+	})
+	_, err = rootCmd.ExecuteC()
+	assert.NoError(t, err)
+	assert.Equal(t, "first", getPublished(t, cid+"/testdata/rollback.txt"))
+
+	out := &bytes.Buffer{}
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+	defer func() { outputFormat = outputText }()
+	rootCmd.SetArgs([]string{
+		"deployments",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+		"--output", "json",
+	})
+	_, err = rootCmd.ExecuteC()
+	assert.NoError(t, err)
+	list := &deploymentsOutput{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), list))
+	assert.Equal(t, 0, list.ExitStatus)
+	deployments := list.Deployments
+	if assert.Len(t, deployments, 2) {
+		assert.False(t, deployments[0].Live)
+		assert.True(t, deployments[1].Live)
+		assert.Equal(t, 1, deployments[1].Files)
+	}
+
+	rootCmd.SetArgs([]string{
+		"rollback",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+		"does-not-exist",
+	})
+	_, err = rootCmd.ExecuteC()
+	assert.EqualError(t, err, "rollback failed: {\"error\":\"deployment not found\"}")
+}
+
+func getPublished(t *testing.T, path string) string {
+	t.Helper()
+
+	resp, err := http.Get(testutil.TestServerURL + "/" + path)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	defer resp.Body.Close()
+
+	out, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	return strings.TrimRight(string(out), "\r\n")
+}
@@ -7,17 +7,22 @@ import (
 )
 
 var rootCmd = &cobra.Command{
-	Use:   "tavern",
-	Short: "Tavern client/server",
+	Use:               "tavern",
+	Short:             "Tavern client/server",
+	PersistentPreRunE: checkOutput,
 }
 
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
+		if jsonOutput() {
+			printJSON(os.Stdout, &errorOutput{Error: err.Error(), ExitStatus: 1})
+		}
 		os.Exit(1)
 	}
 }
 
 func init() {
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputText, "Output format (text or json)")
 }
@@ -2,8 +2,12 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"os"
 
+	"github.com/dustin/go-humanize"
 	"github.com/rubiojr/tavern/server"
+	"github.com/rubiojr/tavern/server/storage"
 	"github.com/spf13/cobra"
 )
 
@@ -17,7 +21,46 @@ var serveCmd = &cobra.Command{
 			UploadsPath:         *path,
 			Addr:                *addr,
 			AllowedCharmServers: *issuers,
+			AllowedCharmIDs:     *allowedIDs,
+			DeniedCharmIDs:      *deniedIDs,
+			CharmIDsFile:        *charmIDsFile,
+			KeepDeployments:     *keepDeployments,
 		}
+
+		fileSize, err := humanize.ParseBytes(*maxFileSize)
+		if err != nil {
+			return fmt.Errorf("invalid --max-file-size: %w", err)
+		}
+		cfg.MaxFileSize = int64(fileSize)
+
+		uploadSize, err := humanize.ParseBytes(*maxUploadSize)
+		if err != nil {
+			return fmt.Errorf("invalid --max-upload-size: %w", err)
+		}
+		cfg.MaxUploadSize = int64(uploadSize)
+
+		if *s3Bucket != "" {
+			s3cfg := &storage.S3Config{
+				Bucket:    *s3Bucket,
+				Prefix:    *s3Prefix,
+				Endpoint:  *s3Endpoint,
+				Region:    *s3Region,
+				AccessKey: *s3AccessKey,
+				SecretKey: *s3SecretKey,
+			}
+			if s3cfg.AccessKey == "" {
+				s3cfg.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+			}
+			if s3cfg.SecretKey == "" {
+				s3cfg.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+			}
+			store, err := storage.NewS3(s3cfg)
+			if err != nil {
+				return err
+			}
+			cfg.Storage = store
+		}
+
 		s := server.NewServerWithConfig(cfg)
 		return s.Serve(context.Background())
 	},
@@ -25,11 +68,27 @@ var serveCmd = &cobra.Command{
 
 var path *string
 var addr *string
-var issuers *[]string
+var issuers, allowedIDs, deniedIDs *[]string
+var charmIDsFile *string
+var keepDeployments *int
+var maxFileSize, maxUploadSize *string
+var s3Bucket, s3Prefix, s3Endpoint, s3Region, s3AccessKey, s3SecretKey *string
 
 func init() {
 	rootCmd.AddCommand(serveCmd)
 	path = serveCmd.Flags().StringP("path", "p", server.ServerDefaultUploadsPath, "Path where the files will be uploaded/served")
 	addr = serveCmd.Flags().StringP("address", "a", server.ServerDefaultAddr, "Listening address")
 	issuers = serveCmd.Flags().StringSliceP("allowed-charm-servers", "w", []string{}, "Allowed Charm servers")
+	allowedIDs = serveCmd.Flags().StringSliceP("allowed-charm-ids", "", []string{}, "Charm IDs allowed to publish (any by default)")
+	deniedIDs = serveCmd.Flags().StringSliceP("denied-charm-ids", "", []string{}, "Charm IDs that can't publish")
+	charmIDsFile = serveCmd.Flags().StringP("charm-ids-file", "", "", "File listing allowed Charm IDs, and denied ones prefixed with '!', reloaded when changed")
+	keepDeployments = serveCmd.Flags().IntP("keep-deployments", "", server.ServerDefaultKeepDeployments, "Number of deployments kept per Charm ID for rollbacks")
+	maxFileSize = serveCmd.Flags().StringP("max-file-size", "", "0", "Maximum size of every published file, like 100MB (0 for no limit)")
+	maxUploadSize = serveCmd.Flags().StringP("max-upload-size", "", "0", "Maximum size of a publish request, like 1GB (0 for no limit)")
+	s3Bucket = serveCmd.Flags().StringP("s3-bucket", "", "", "Store files in this S3 bucket instead of the local filesystem")
+	s3Prefix = serveCmd.Flags().StringP("s3-prefix", "", "", "S3 key prefix")
+	s3Endpoint = serveCmd.Flags().StringP("s3-endpoint", "", "", "S3 compatible endpoint URL (defaults to AWS)")
+	s3Region = serveCmd.Flags().StringP("s3-region", "", storage.S3DefaultRegion, "S3 region")
+	s3AccessKey = serveCmd.Flags().StringP("s3-access-key", "", "", "S3 access key (defaults to AWS_ACCESS_KEY_ID)")
+	s3SecretKey = serveCmd.Flags().StringP("s3-secret-key", "", "", "S3 secret key (defaults to AWS_SECRET_ACCESS_KEY)")
 }
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/rubiojr/tavern/client"
+	"github.com/spf13/cobra"
+)
+
+type siteOutput struct {
+	Site       *client.Site `json:"site"`
+	ExitStatus int          `json:"exit_status"`
+}
+
+type sitesOutput struct {
+	Sites      []*client.Site `json:"sites"`
+	ExitStatus int            `json:"exit_status"`
+}
+
+var sitesCmd = &cobra.Command{
+	Use:   "sites",
+	Short: "Manage the names of your sites",
+}
+
+var sitesClaimCmd = &cobra.Command{
+	Use:   "claim <name>",
+	Short: "Claim a name for your site",
+	Long:  "Claim a name for your site, to serve it at /<name> instead of /<charm-id> (or /<charm-id>~<site> with --site). Names are lowercase letters, digits and dashes, and unique in a Tavern server.",
+	Args:  cobra.ExactArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pc, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := clientContext(cmd)
+		defer cancel()
+
+		s, err := pc.ClaimSiteContext(ctx, args[0])
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput() {
+			return printJSON(cmd.OutOrStdout(), &siteOutput{Site: s})
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Site name %s claimed, your site is served at %s\n", s.Name, s.URL)
+		return nil
+	},
+}
+
+var sitesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your site names",
+	Args:  cobra.NoArgs,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pc, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := clientContext(cmd)
+		defer cancel()
+
+		sites, err := pc.SitesContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput() {
+			return printJSON(cmd.OutOrStdout(), &sitesOutput{Sites: sites})
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSITE\tURL\tCLAIMED")
+		for _, s := range sites {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Name, s.Site, s.URL, s.Created.Local().Format("2006-01-02 15:04:05"))
+		}
+		return w.Flush()
+	},
+}
+
+var sitesReleaseCmd = &cobra.Command{
+	Use:   "release <name>",
+	Short: "Release a site name, so others can claim it",
+	Args:  cobra.ExactArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pc, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := clientContext(cmd)
+		defer cancel()
+
+		s, err := pc.ReleaseSiteContext(ctx, args[0])
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput() {
+			return printJSON(cmd.OutOrStdout(), &siteOutput{Site: s})
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Site name %s released\n", s.Name)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sitesCmd)
+	for _, cmd := range []*cobra.Command{sitesClaimCmd, sitesListCmd, sitesReleaseCmd} {
+		sitesCmd.AddCommand(cmd)
+		addClientFlags(cmd)
+	}
+	addSiteFlag(sitesClaimCmd)
+}
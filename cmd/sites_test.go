@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/rubiojr/tavern/internal/testutil"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSites(t *testing.T) {
+	cc, err := testutil.CharmClient()
+	if err != nil {
+		assert.FailNow(t, "error starting charm client", err)
+	}
+
+	cid, err := cc.ID()
+	if err != nil {
+		assert.FailNow(t, "error retrieving charm ID", err)
+	}
+
+	tdir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = testutil.TavernServer(ctx, tdir)
+	assert.NoError(t, err)
+
+	for _, name := range []string{"Not Valid", "tavern"} {
+		rootCmd.SetArgs([]string{
+			"sites", "claim", name,
+			"--charm-server-host", testutil.CharmServerHost,
+			"--server-url", testutil.TestServerURL,
+		})
+		_, err = rootCmd.ExecuteContextC(ctx)
+		assert.Error(t, err, name)
+	}
+
+	out := &bytes.Buffer{}
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{
+		"sites", "claim", "docs",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "Site name docs claimed, your site is served at "+testutil.TestServerURL+"/docs\n", out.String())
+
+	out.Reset()
+	rootCmd.SetArgs([]string{
+		"sites", "list",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+		"--output", "json",
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	outputFormat = outputText
+	assert.NoError(t, err)
+	list := &sitesOutput{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), list))
+	assert.Equal(t, 0, list.ExitStatus)
+	if assert.Len(t, list.Sites, 1) {
+		assert.Equal(t, "docs", list.Sites[0].Name)
+		assert.Equal(t, cid, list.Sites[0].Site)
+	}
+
+	out.Reset()
+	defer func() { *local = false }()
+	rootCmd.SetArgs([]string{
+		"publish",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+		"--local",
+		"testdata",
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Visit "+testutil.TestServerURL+"/docs\n")
+	assert.Equal(t, "foo", getPublished(t, "docs/test.txt"))
+	assert.Equal(t, "foo", getPublished(t, cid+"/test.txt"))
+
+	rootCmd.SetArgs([]string{
+		"sites", "release", "docs",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.NoError(t, err)
+	resp, err := http.Get(testutil.TestServerURL + "/docs/test.txt")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, 404, resp.StatusCode)
+	}
+}
+
+func TestNamedSites(t *testing.T) {
+	cc, err := testutil.CharmClient()
+	if err != nil {
+		assert.FailNow(t, "error starting charm client", err)
+	}
+
+	cid, err := cc.ID()
+	if err != nil {
+		assert.FailNow(t, "error retrieving charm ID", err)
+	}
+
+	tdir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = testutil.TavernServer(ctx, tdir)
+	assert.NoError(t, err)
+
+	// Subcommands keep the context of their first execution, cancelled
+	// by the tests that ran them before
+	cmds := []*cobra.Command{publishCmd, deploymentsCmd, sitesClaimCmd}
+	for _, cmd := range cmds {
+		cmd.SetContext(nil)
+	}
+	defer func() {
+		for _, cmd := range cmds {
+			cmd.SetContext(nil)
+		}
+	}()
+
+	out := &bytes.Buffer{}
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+	defer func() { *local = false }()
+	defer func() { siteName = "" }()
+	rootCmd.SetArgs([]string{
+		"publish",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+		"--local",
+		"--site", "blog",
+		"testdata",
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Visit "+testutil.TestServerURL+"/"+cid+"~blog\n")
+	assert.Equal(t, "foo", getPublished(t, cid+"~blog/test.txt"))
+
+	// The default site is a different site
+	resp, err := http.Get(testutil.TestServerURL + "/" + cid + "/test.txt")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, 404, resp.StatusCode)
+	}
+
+	// Every site has its own deployments
+	defer func() { outputFormat = outputText }()
+	for site, count := range map[string]int{"blog": 1, "": 0} {
+		out.Reset()
+		rootCmd.SetArgs([]string{
+			"deployments",
+			"--charm-server-host", testutil.CharmServerHost,
+			"--server-url", testutil.TestServerURL,
+			"--output", "json",
+			"--site", site,
+		})
+		_, err = rootCmd.ExecuteContextC(ctx)
+		if assert.NoError(t, err, site) {
+			list := &deploymentsOutput{}
+			assert.NoError(t, json.Unmarshal(out.Bytes(), list))
+			assert.Len(t, list.Deployments, count, site)
+		}
+	}
+	outputFormat = outputText
+
+	out.Reset()
+	rootCmd.SetArgs([]string{
+		"sites", "claim", "blog",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+		"--site", "blog",
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "Site name blog claimed, your site is served at "+testutil.TestServerURL+"/blog\n", out.String())
+	assert.Equal(t, "foo", getPublished(t, "blog/test.txt"))
+
+	rootCmd.SetArgs([]string{
+		"publish",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+		"--site", "../blog",
+		"testdata",
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.Error(t, err)
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rubiojr/tavern/client"
+	"github.com/spf13/cobra"
+)
+
+const defaultTokenTTL = 30 * 24 * time.Hour
+
+var tokenTTL *time.Duration
+
+type tokenOutput struct {
+	Token      *client.Token `json:"token"`
+	Secret     string        `json:"secret,omitempty"`
+	ExitStatus int           `json:"exit_status"`
+}
+
+type tokensOutput struct {
+	Tokens     []*client.Token `json:"tokens"`
+	ExitStatus int             `json:"exit_status"`
+}
+
+var tokensCmd = &cobra.Command{
+	Use:   "tokens",
+	Short: "Manage deploy tokens, to publish without a Charm account",
+}
+
+var tokensCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create a deploy token",
+	Long:  "Create a deploy token, to publish local files to your site without a Charm account, from CI for example. The token is only shown once.",
+	Args:  cobra.MaximumNArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pc, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		name := ""
+		if len(args) > 0 {
+			name = args[0]
+		}
+
+		ctx, cancel := clientContext(cmd)
+		defer cancel()
+
+		t, secret, err := pc.CreateTokenContext(ctx, name, *tokenTTL)
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput() {
+			return printJSON(cmd.OutOrStdout(), &tokenOutput{Token: t, Secret: secret})
+		}
+
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Token %s created, expires %s\n", t.ID, t.Expires.Local().Format("2006-01-02 15:04:05"))
+		fmt.Fprintln(out, secret)
+		fmt.Fprintln(out, "Store it safely, it won't be shown again. Publish with it using TAVERN_TOKEN or --token.")
+		return nil
+	},
+}
+
+var tokensListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your deploy tokens",
+	Args:  cobra.NoArgs,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pc, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := clientContext(cmd)
+		defer cancel()
+
+		tokens, err := pc.TokensContext(ctx)
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput() {
+			return printJSON(cmd.OutOrStdout(), &tokensOutput{Tokens: tokens})
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSITE\tCREATED\tEXPIRES")
+		for _, t := range tokens {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, t.Site, t.Created.Local().Format("2006-01-02 15:04:05"), t.Expires.Local().Format("2006-01-02 15:04:05"))
+		}
+		return w.Flush()
+	},
+}
+
+var tokensRevokeCmd = &cobra.Command{
+	Use:   "revoke <token-id>",
+	Short: "Revoke a deploy token",
+	Args:  cobra.ExactArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pc, err := newClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := clientContext(cmd)
+		defer cancel()
+
+		t, err := pc.RevokeTokenContext(ctx, args[0])
+		if err != nil {
+			return err
+		}
+
+		if jsonOutput() {
+			return printJSON(cmd.OutOrStdout(), &tokenOutput{Token: t})
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Token %s revoked\n", t.ID)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tokensCmd)
+	for _, cmd := range []*cobra.Command{tokensCreateCmd, tokensListCmd, tokensRevokeCmd} {
+		tokensCmd.AddCommand(cmd)
+		addClientFlags(cmd)
+	}
+	addSiteFlag(tokensCreateCmd)
+	tokenTTL = tokensCreateCmd.Flags().DurationP("expires", "", defaultTokenTTL, "Time the token is valid for (up to a year)")
+}
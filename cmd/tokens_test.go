@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/rubiojr/tavern/client"
+	"github.com/rubiojr/tavern/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokens(t *testing.T) {
+	cc, err := testutil.CharmClient()
+	if err != nil {
+		assert.FailNow(t, "error starting charm client", err)
+	}
+
+	cid, err := cc.ID()
+	if err != nil {
+		assert.FailNow(t, "error retrieving charm ID", err)
+	}
+
+	tdir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err = testutil.TavernServer(ctx, tdir)
+	assert.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+	defer func() { outputFormat = outputText }()
+	rootCmd.SetArgs([]string{
+		"tokens", "create", "ci",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+		"--expires", "1h",
+		"--output", "json",
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.NoError(t, err)
+	created := &tokenOutput{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), created))
+	assert.Equal(t, "ci", created.Token.Name)
+	assert.Equal(t, cid, created.Token.Site)
+	assert.NotEmpty(t, created.Secret)
+
+	// Publishing with the token doesn't talk to the Charm server
+	defer func() { *local, *token = false, "" }()
+	publish := []string{
+		"publish",
+		"--charm-server-host", "charm.invalid",
+		"--server-url", testutil.TestServerURL,
+		"--token", created.Secret,
+		"--local",
+		"testdata",
+	}
+	rootCmd.SetArgs(publish)
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", getPublished(t, cid+"/test.txt"))
+
+	// Dry runs with the token contact neither the Charm nor the Tavern server
+	out.Reset()
+	outputFormat = outputText
+	defer func() { *dryRun = false }()
+	rootCmd.SetArgs([]string{
+		"publish",
+		"--charm-server-host", "charm.invalid",
+		"--server-url", "http://127.0.0.1:1",
+		"--token", created.Secret,
+		"--local",
+		"--dry-run",
+		"testdata",
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "/test.txt")
+	assert.Contains(t, out.String(), "would be published to the site of the deploy token\n")
+	*dryRun = false
+
+	*local = false
+	rootCmd.SetArgs([]string{
+		"publish",
+		"--server-url", testutil.TestServerURL,
+		"--token", created.Secret,
+		"testdata/test.txt",
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.ErrorIs(t, err, client.ErrCharmAccount)
+
+	out.Reset()
+	rootCmd.SetArgs([]string{
+		"tokens", "list",
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+		"--output", "json",
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.NoError(t, err)
+	list := &tokensOutput{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), list))
+	assert.Equal(t, 0, list.ExitStatus)
+	if assert.Len(t, list.Tokens, 1) {
+		assert.Equal(t, created.Token.ID, list.Tokens[0].ID)
+	}
+
+	rootCmd.SetArgs([]string{
+		"tokens", "revoke", created.Token.ID,
+		"--charm-server-host", testutil.CharmServerHost,
+		"--server-url", testutil.TestServerURL,
+	})
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.NoError(t, err)
+
+	rootCmd.SetArgs(publish)
+	_, err = rootCmd.ExecuteContextC(ctx)
+	assert.EqualError(t, err, "publishing failed: {\"error\":\"invalid deploy token\"}")
+}
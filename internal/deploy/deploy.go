@@ -0,0 +1,876 @@
+// Package deploy manages the deployments of published sites.
+//
+// Published files are stored as content addressed blobs, and every publish
+// creates a new deployment: a manifest mapping site paths to blobs. A
+// deployment only becomes live once all its files have been stored, by
+// swapping the live pointer, a single object write in the storage backend.
+package deploy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rubiojr/tavern/server/storage"
+)
+
+const blobsDir = "blobs/"
+const deploymentsDir = "deployments/"
+const tmpDir = "tmp/"
+const livePointer = "live"
+
+// Maximum number of deployment manifests kept in memory
+const cacheSize = 128
+
+// Unreferenced blobs and temporary files younger than this are not
+// removed, as they may belong to a deployment being staged.
+const gcGracePeriod = time.Hour
+
+// ErrNoPrevious is returned when rolling back without a deployment older
+// than the live one.
+var ErrNoPrevious = errors.New("no previous deployment to roll back to")
+
+// ErrMissingFile is returned when committing a deployment that references
+// a file not stored in the server.
+var ErrMissingFile = errors.New("file not stored")
+
+// ErrChecksumMismatch is returned when the content of a file doesn't match
+// its expected hash.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Deployment is an immutable snapshot of a published site.
+type Deployment struct {
+	ID      string           `json:"id"`
+	Created time.Time        `json:"created"`
+	Files   map[string]*File `json:"files"`
+}
+
+// File is a file in a deployment, stored in the blob named after its
+// SHA-256 hash.
+type File struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Manifest lists the files of a site with their hashes, as sent by
+// clients to publish only the files the server doesn't have.
+type Manifest struct {
+	Files []*ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Info summarizes a deployment.
+type Info struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+	Files   int       `json:"files"`
+	Live    bool      `json:"live"`
+}
+
+func (d *Deployment) Info() *Info {
+	info := &Info{ID: d.ID, Created: d.Created, Files: len(d.Files)}
+	for _, f := range d.Files {
+		info.Size += f.Size
+	}
+
+	return info
+}
+
+type Manager struct {
+	store storage.Storage
+	keep  int
+	// Serializes deployment changes per Charm ID, and uploads per blob
+	lmu   sync.Mutex
+	locks map[string]*keyLock
+	cache map[string]*Deployment
+	cmu   sync.Mutex
+}
+
+// NewManager returns a deployment manager that keeps the last keep
+// deployments for every Charm ID, and the live one.
+func NewManager(store storage.Storage, keep int) *Manager {
+	if keep < 1 {
+		keep = 1
+	}
+
+	return &Manager{store: store, keep: keep, cache: map[string]*Deployment{}, locks: map[string]*keyLock{}}
+}
+
+// Staging is a deployment being built. Files are added with Put and the
+// deployment goes live with Commit.
+type Staging struct {
+	m       *Manager
+	charmID string
+	files   map[string]*File
+	mirror  bool
+	deleted []string
+}
+
+// Stage starts a new deployment for charmID. When committed, the files
+// added replace or extend the ones in the live deployment. In mirror mode,
+// the deployment only has the files added, and the ones in the live
+// deployment are deleted.
+func (m *Manager) Stage(charmID string, mirror bool) *Staging {
+	return &Staging{m: m, charmID: charmID, files: map[string]*File{}, mirror: mirror}
+}
+
+// Put stores the contents of r in the staging deployment as path.
+func (s *Staging) Put(path string, r io.Reader) error {
+	path, err := storage.CleanPath(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := s.m.putBlob(s.charmID, r)
+	if err != nil {
+		return err
+	}
+	s.files[path] = f
+
+	return nil
+}
+
+// PutVerified stores the contents of r in the staging deployment as path,
+// verifying it matches the given SHA-256 hash. Content already stored is not
+// written again.
+func (s *Staging) PutVerified(path, sum string, r io.Reader) error {
+	path, err := storage.CleanPath(path)
+	if err != nil {
+		return err
+	}
+
+	if !validHash(sum) {
+		return fmt.Errorf("invalid SHA-256 hash for %s", path)
+	}
+
+	if info, err := s.m.store.Stat(s.charmID, blobPath(sum)); err == nil {
+		s.files[path] = &File{SHA256: sum, Size: info.Size}
+		return nil
+	}
+
+	// The content is verified before it's stored as the blob, so blobs
+	// only ever have the content matching their hash
+	tmp, f, err := s.m.putTmp(s.charmID, r)
+	if err != nil {
+		return err
+	}
+	defer s.m.store.Delete(s.charmID, tmp)
+
+	if f.SHA256 != sum {
+		return fmt.Errorf("%w for %s", ErrChecksumMismatch, path)
+	}
+
+	err = s.m.moveBlob(s.charmID, tmp, sum)
+	if err != nil {
+		return err
+	}
+	s.files[path] = f
+
+	return nil
+}
+
+// Link adds a file already stored in the server to the staging
+// deployment, referenced by its hash.
+func (s *Staging) Link(path string, f *File) error {
+	path, err := storage.CleanPath(path)
+	if err != nil {
+		return err
+	}
+
+	if !validHash(f.SHA256) {
+		return fmt.Errorf("invalid SHA-256 hash for %s", path)
+	}
+	s.files[path] = f
+
+	return nil
+}
+
+// File returns the file staged as path, if any.
+func (s *Staging) File(path string) (*File, bool) {
+	path, err := storage.CleanPath(path)
+	if err != nil {
+		return nil, false
+	}
+
+	f, ok := s.files[path]
+	return f, ok
+}
+
+// Deleted returns the paths in the previous live deployment not present in
+// the committed mirror deployment.
+func (s *Staging) Deleted() []string {
+	return s.deleted
+}
+
+// Commit creates the deployment and makes it live.
+func (s *Staging) Commit() (*Deployment, error) {
+	unlock := s.m.lock(s.charmID)
+	defer unlock()
+
+	live, err := s.m.Live(s.charmID)
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return nil, err
+	}
+
+	if live == nil {
+		live, err = s.m.importLegacy(s.charmID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files := map[string]*File{}
+	s.deleted = []string{}
+	if live != nil {
+		for p, f := range live.Files {
+			if !s.mirror {
+				files[p] = f
+			} else if _, ok := s.files[p]; !ok {
+				s.deleted = append(s.deleted, p)
+			}
+		}
+	}
+	sort.Strings(s.deleted)
+	for p, f := range s.files {
+		// Blobs may be garbage collected while staging
+		info, err := s.m.store.Stat(s.charmID, blobPath(f.SHA256))
+		if err != nil {
+			if errors.Is(err, storage.ErrNotExist) {
+				return nil, fmt.Errorf("%w: %s", ErrMissingFile, p)
+			}
+			return nil, err
+		}
+		files[p] = &File{SHA256: f.SHA256, Size: info.Size}
+	}
+
+	d, err := s.m.create(s.charmID, files)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.m.activate(s.charmID, d)
+	if err != nil {
+		return nil, err
+	}
+
+	// The deployment is live already, cleaning up can be retried on the
+	// next one
+	err = s.m.prune(s.charmID, d.ID)
+	if err != nil {
+		log.Printf("error pruning deployments for %s: %s", s.charmID, err)
+	}
+
+	return d, nil
+}
+
+// Missing returns the paths in the manifest with content not stored in the
+// server, that need to be uploaded.
+func (m *Manager) Missing(charmID string, manifest *Manifest) ([]string, error) {
+	stored := map[string]bool{}
+	live, err := m.Live(charmID)
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return nil, err
+	}
+	if live != nil {
+		for _, f := range live.Files {
+			stored[f.SHA256] = true
+		}
+	}
+
+	missing := []string{}
+	for _, f := range manifest.Files {
+		if !validHash(f.SHA256) {
+			return nil, fmt.Errorf("invalid SHA-256 hash for %s", f.Path)
+		}
+
+		found, ok := stored[f.SHA256]
+		if !ok {
+			_, err := m.store.Stat(charmID, blobPath(f.SHA256))
+			if err != nil && !errors.Is(err, storage.ErrNotExist) {
+				return nil, err
+			}
+			found = err == nil
+			stored[f.SHA256] = found
+		}
+
+		if !found {
+			missing = append(missing, f.Path)
+		}
+	}
+
+	return missing, nil
+}
+
+// List returns the deployments for charmID, newest first.
+func (m *Manager) List(charmID string) ([]*Info, error) {
+	deployments, err := m.list(charmID)
+	if err != nil {
+		return nil, err
+	}
+
+	liveID, err := m.liveID(charmID)
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return nil, err
+	}
+
+	infos := []*Info{}
+	for i := len(deployments) - 1; i >= 0; i-- {
+		info := deployments[i].Info()
+		info.Live = info.ID == liveID
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// Rollback makes the deployment with the given ID live again. If id is
+// empty, the deployment created before the live one is used.
+func (m *Manager) Rollback(charmID, id string) (*Deployment, error) {
+	unlock := m.lock(charmID)
+	defer unlock()
+
+	if id != "" {
+		d, err := m.Get(charmID, id)
+		if err != nil {
+			return nil, err
+		}
+		return d, m.activate(charmID, d)
+	}
+
+	liveID, err := m.liveID(charmID)
+	if err != nil {
+		return nil, err
+	}
+
+	deployments, err := m.list(charmID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, d := range deployments {
+		if d.ID != liveID {
+			continue
+		}
+		if i == 0 {
+			break
+		}
+		return deployments[i-1], m.activate(charmID, deployments[i-1])
+	}
+
+	return nil, ErrNoPrevious
+}
+
+// Live returns the live deployment for charmID, or storage.ErrNotExist if
+// nothing was published yet.
+func (m *Manager) Live(charmID string) (*Deployment, error) {
+	id, err := m.liveID(charmID)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Get(charmID, id)
+}
+
+// Get returns the deployment with the given ID.
+func (m *Manager) Get(charmID, id string) (*Deployment, error) {
+	if id == "" || strings.Trim(id, "0123456789abcdef-") != "" {
+		return nil, storage.ErrNotExist
+	}
+
+	key := charmID + "/" + id
+	m.cmu.Lock()
+	d, ok := m.cache[key]
+	m.cmu.Unlock()
+	if ok {
+		return d, nil
+	}
+
+	r, err := m.store.Get(charmID, deploymentsDir+id+".json")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	d = &Deployment{}
+	err = json.NewDecoder(r).Decode(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deployment %s: %w", id, err)
+	}
+
+	m.cmu.Lock()
+	if len(m.cache) >= cacheSize {
+		for k := range m.cache {
+			delete(m.cache, k)
+			break
+		}
+	}
+	m.cache[key] = d
+	m.cmu.Unlock()
+
+	return d, nil
+}
+
+// Stat returns information about a file in the live deployment.
+func (m *Manager) Stat(charmID, path string) (*storage.FileInfo, error) {
+	live, f, err := m.resolve(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if live == nil {
+		return m.store.Stat(charmID, path)
+	}
+
+	return &storage.FileInfo{Path: path, Size: f.Size, ModTime: live.Created}, nil
+}
+
+// Open opens a file in the live deployment.
+func (m *Manager) Open(charmID, path string) (io.ReadCloser, *storage.FileInfo, error) {
+	live, f, err := m.resolve(charmID, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := path
+	info := &storage.FileInfo{Path: path}
+	if live == nil {
+		i, err := m.store.Stat(charmID, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		info = i
+	} else {
+		key = blobPath(f.SHA256)
+		info.Size = f.Size
+		info.ModTime = live.Created
+	}
+
+	r, err := m.store.Get(charmID, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return r, info, nil
+}
+
+// ReadDir returns the names of the files and directories in dir of the live
+// deployment, sorted, with a trailing slash for directories. An empty dir
+// is the root of the site. Directories without files don't exist.
+func (m *Manager) ReadDir(charmID, dir string) ([]string, error) {
+	prefix := ""
+	if dir != "" {
+		dir, err := storage.CleanPath(dir)
+		if err != nil {
+			return nil, storage.ErrNotExist
+		}
+		prefix = dir + "/"
+	}
+
+	paths := []string{}
+	live, err := m.Live(charmID)
+	switch {
+	case errors.Is(err, storage.ErrNotExist):
+		files, err := m.store.List(charmID)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if !isInternal(f.Path) {
+				paths = append(paths, f.Path)
+			}
+		}
+	case err != nil:
+		return nil, err
+	default:
+		for p := range live.Files {
+			paths = append(paths, p)
+		}
+	}
+
+	seen := map[string]bool{}
+	names := []string{}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(p, prefix)
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i+1]
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, storage.ErrNotExist
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Finds path in the live deployment. If there's no live deployment, sites
+// published before deployments existed are served from their legacy path
+// and a nil deployment is returned.
+func (m *Manager) resolve(charmID, path string) (*Deployment, *File, error) {
+	path, err := storage.CleanPath(path)
+	if err != nil {
+		return nil, nil, storage.ErrNotExist
+	}
+
+	live, err := m.Live(charmID)
+	if errors.Is(err, storage.ErrNotExist) {
+		if isInternal(path) {
+			return nil, nil, storage.ErrNotExist
+		}
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, ok := live.Files[path]
+	if !ok {
+		return nil, nil, storage.ErrNotExist
+	}
+
+	return live, f, nil
+}
+
+// Returns the deployments for charmID, oldest first.
+func (m *Manager) list(charmID string) ([]*Deployment, error) {
+	files, err := m.store.List(charmID)
+	if err != nil {
+		return nil, err
+	}
+
+	deployments := []*Deployment{}
+	for _, f := range files {
+		if !strings.HasPrefix(f.Path, deploymentsDir) || !strings.HasSuffix(f.Path, ".json") {
+			continue
+		}
+
+		id := strings.TrimSuffix(strings.TrimPrefix(f.Path, deploymentsDir), ".json")
+		d, err := m.Get(charmID, id)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, d)
+	}
+
+	sort.Slice(deployments, func(i, j int) bool {
+		if deployments[i].Created.Equal(deployments[j].Created) {
+			return deployments[i].ID < deployments[j].ID
+		}
+		return deployments[i].Created.Before(deployments[j].Created)
+	})
+
+	return deployments, nil
+}
+
+// Removes the deployments older than the last m.keep ones, except the live
+// one, the blobs no longer referenced by any deployment and the expired
+// uploads.
+// Must be called with the Charm ID lock held.
+func (m *Manager) prune(charmID, liveID string) error {
+	deployments, err := m.list(charmID)
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	referenced := map[string]struct{}{}
+	for i, d := range deployments {
+		if i >= len(deployments)-m.keep || d.ID == liveID {
+			for _, f := range d.Files {
+				referenced[f.SHA256] = struct{}{}
+			}
+			continue
+		}
+		removed = true
+
+		err = m.store.Delete(charmID, deploymentsDir+d.ID+".json")
+		if err != nil {
+			return err
+		}
+		m.cmu.Lock()
+		delete(m.cache, charmID+"/"+d.ID)
+		m.cmu.Unlock()
+	}
+
+	files, err := m.store.List(charmID)
+	if err != nil {
+		return err
+	}
+
+	err = m.expireUploads(charmID, files)
+	if err != nil {
+		return err
+	}
+
+	if !removed {
+		return nil
+	}
+
+	for _, f := range files {
+		if time.Since(f.ModTime) < gcGracePeriod {
+			continue
+		}
+
+		if strings.HasPrefix(f.Path, blobsDir) {
+			if _, ok := referenced[f.Path[strings.LastIndex(f.Path, "/")+1:]]; ok {
+				continue
+			}
+		} else if !strings.HasPrefix(f.Path, tmpDir) {
+			continue
+		}
+
+		err = m.store.Delete(charmID, f.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) liveID(charmID string) (string, error) {
+	r, err := m.store.Get(charmID, livePointer)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	id, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(id)), nil
+}
+
+func (m *Manager) create(charmID string, files map[string]*File) (*Deployment, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Deployment{ID: id, Created: time.Now().UTC(), Files: files}
+	buf, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.store.Put(charmID, deploymentsDir+id+".json", bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Makes d the live deployment. Must be called with the Charm ID lock held.
+func (m *Manager) activate(charmID string, d *Deployment) error {
+	return m.store.Put(charmID, livePointer, strings.NewReader(d.ID))
+}
+
+// Stores the contents of r as a blob, unless a blob with the same content
+// is already stored.
+func (m *Manager) putBlob(charmID string, r io.Reader) (*File, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		h := sha256.New()
+		size, err := io.Copy(h, rs)
+		if err != nil {
+			return nil, err
+		}
+		sum := hex.EncodeToString(h.Sum(nil))
+		f := &File{SHA256: sum, Size: size}
+
+		if _, err := m.store.Stat(charmID, blobPath(sum)); err == nil {
+			return f, nil
+		}
+
+		_, err = rs.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+
+		return f, m.store.Put(charmID, blobPath(sum), rs)
+	}
+
+	// The hash is only known once the data has been read, so write it to a
+	// temporary file first.
+	tmp, f, err := m.putTmp(charmID, r)
+	if err != nil {
+		return nil, err
+	}
+	defer m.store.Delete(charmID, tmp)
+
+	return f, m.moveBlob(charmID, tmp, f.SHA256)
+}
+
+// Writes the contents of r to a temporary file, returning its path and
+// the hash and size of the content. The caller removes the file.
+func (m *Manager) putTmp(charmID string, r io.Reader) (string, *File, error) {
+	id, err := newID()
+	if err != nil {
+		return "", nil, err
+	}
+	tmp := tmpDir + id
+
+	h := sha256.New()
+	hr := &countingReader{r: io.TeeReader(r, h)}
+	err = m.store.Put(charmID, tmp, hr)
+	if err != nil {
+		m.store.Delete(charmID, tmp)
+		return "", nil, err
+	}
+
+	return tmp, &File{SHA256: hex.EncodeToString(h.Sum(nil)), Size: hr.n}, nil
+}
+
+// Stores the temporary file tmp as the blob with the given hash, unless
+// the blob is already stored. The content of tmp must match the hash.
+func (m *Manager) moveBlob(charmID, tmp, sum string) error {
+	if _, err := m.store.Stat(charmID, blobPath(sum)); err == nil {
+		return nil
+	}
+
+	return m.store.Rename(charmID, tmp, blobPath(sum))
+}
+
+// Creates the first deployment from the files published before
+// deployments existed, and removes them from their legacy location.
+func (m *Manager) importLegacy(charmID string) (*Deployment, error) {
+	files, err := m.store.List(charmID)
+	if err != nil {
+		return nil, err
+	}
+
+	legacy := map[string]*File{}
+	for _, fi := range files {
+		if isInternal(fi.Path) {
+			continue
+		}
+
+		r, err := m.store.Get(charmID, fi.Path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := m.putBlob(charmID, r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		legacy[fi.Path] = f
+	}
+
+	if len(legacy) == 0 {
+		return nil, nil
+	}
+
+	d, err := m.create(charmID, legacy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.activate(charmID, d)
+	if err != nil {
+		return nil, err
+	}
+
+	for p := range legacy {
+		m.store.Delete(charmID, p)
+	}
+
+	return d, nil
+}
+
+type keyLock struct {
+	sync.Mutex
+	// Holders of the lock and the ones waiting for it
+	refs int
+}
+
+// Locks key and returns the function unlocking it. Locks are forgotten once
+// nobody holds or waits for them.
+func (m *Manager) lock(key string) func() {
+	m.lmu.Lock()
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyLock{}
+		m.locks[key] = l
+	}
+	l.refs++
+	m.lmu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.lmu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(m.locks, key)
+		}
+		m.lmu.Unlock()
+	}
+}
+
+func blobPath(sum string) string {
+	return blobsDir + sum[:2] + "/" + sum
+}
+
+func validHash(sum string) bool {
+	if len(sum) != sha256.Size*2 || strings.ToLower(sum) != sum {
+		return false
+	}
+	_, err := hex.DecodeString(sum)
+	return err == nil
+}
+
+func isInternal(path string) bool {
+	return path == livePointer ||
+		strings.HasPrefix(path, blobsDir) ||
+		strings.HasPrefix(path, deploymentsDir) ||
+		strings.HasPrefix(path, tmpDir) ||
+		strings.HasPrefix(path, uploadsDir)
+}
+
+// Deployment IDs sort by creation time
+func newID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return time.Now().UTC().Format("20060102150405") + "-" + hex.EncodeToString(b), nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
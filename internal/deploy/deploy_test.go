@@ -0,0 +1,235 @@
+package deploy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/rubiojr/tavern/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const charmID = "b4ede63d-c736-4561-80e9-0f912337b251"
+
+func TestStaging(t *testing.T) {
+	m := NewManager(storage.NewLocal(t.TempDir()), 10)
+
+	_, err := m.Live(charmID)
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	s := m.Stage(charmID, false)
+	assert.NoError(t, s.Put("/index.html", strings.NewReader("index")))
+	assert.NoError(t, s.Put("/about.html", strings.NewReader("about")))
+
+	// Nothing is visible until the deployment is committed
+	_, err = m.Stat(charmID, "index.html")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	first, err := s.Commit()
+	assert.NoError(t, err)
+	assertFile(t, m, "index.html", "index")
+
+	s = m.Stage(charmID, false)
+	assert.NoError(t, s.Put("index.html", strings.NewReader("new index")))
+	second, err := s.Commit()
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	// New files replace the live ones, the rest are kept
+	assertFile(t, m, "index.html", "new index")
+	assertFile(t, m, "about.html", "about")
+
+	_, err = m.Stat(charmID, "live")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+}
+
+func TestImportLegacy(t *testing.T) {
+	store := storage.NewLocal(t.TempDir())
+	m := NewManager(store, 10)
+	assert.NoError(t, store.Put(charmID, "index.html", strings.NewReader("legacy")))
+	assertFile(t, m, "index.html", "legacy")
+
+	s := m.Stage(charmID, false)
+	assert.NoError(t, s.Put("about.html", strings.NewReader("about")))
+	_, err := s.Commit()
+	assert.NoError(t, err)
+
+	assertFile(t, m, "index.html", "legacy")
+	assertFile(t, m, "about.html", "about")
+	_, err = store.Stat(charmID, "index.html")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+}
+
+func assertFile(t *testing.T, m *Manager, path, content string) {
+	t.Helper()
+
+	r, _, err := m.Open(charmID, path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer r.Close()
+
+	out, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(out))
+}
+
+func TestRollback(t *testing.T) {
+	m := NewManager(storage.NewLocal(t.TempDir()), 2)
+
+	_, err := m.Rollback(charmID, "")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	ids := []string{}
+	for _, content := range []string{"one", "two", "three"} {
+		s := m.Stage(charmID, false)
+		assert.NoError(t, s.Put("index.html", strings.NewReader(content)))
+		d, err := s.Commit()
+		assert.NoError(t, err)
+		ids = append(ids, d.ID)
+	}
+
+	// Only the last two deployments are kept
+	infos, err := m.List(charmID)
+	assert.NoError(t, err)
+	assert.Len(t, infos, 2)
+	assert.Equal(t, ids[2], infos[0].ID)
+	assert.True(t, infos[0].Live)
+	assert.Equal(t, ids[1], infos[1].ID)
+	assert.False(t, infos[1].Live)
+
+	d, err := m.Rollback(charmID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, ids[1], d.ID)
+	assertFile(t, m, "index.html", "two")
+
+	_, err = m.Rollback(charmID, "")
+	assert.ErrorIs(t, err, ErrNoPrevious)
+
+	_, err = m.Rollback(charmID, ids[0])
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	_, err = m.Rollback(charmID, ids[2])
+	assert.NoError(t, err)
+	assertFile(t, m, "index.html", "three")
+}
+
+func TestMissing(t *testing.T) {
+	m := NewManager(storage.NewLocal(t.TempDir()), 10)
+
+	s := m.Stage(charmID, false)
+	assert.NoError(t, s.Put("index.html", strings.NewReader("index")))
+	_, err := s.Commit()
+	assert.NoError(t, err)
+	index, _ := s.File("index.html")
+
+	other := strings.Repeat("0", 64)
+	missing, err := m.Missing(charmID, &Manifest{Files: []*ManifestFile{
+		{Path: "/index.html", SHA256: index.SHA256, Size: index.Size},
+		{Path: "/copy.html", SHA256: index.SHA256, Size: index.Size},
+		{Path: "/new.html", SHA256: other, Size: 3},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/new.html"}, missing)
+
+	_, err = m.Missing(charmID, &Manifest{Files: []*ManifestFile{{Path: "/bad", SHA256: "../live"}}})
+	assert.Error(t, err)
+
+	// Files already stored can be published by hash
+	s = m.Stage(charmID, false)
+	assert.NoError(t, s.Link("copy.html", index))
+	_, err = s.Commit()
+	assert.NoError(t, err)
+	assertFile(t, m, "copy.html", "index")
+
+	s = m.Stage(charmID, false)
+	assert.NoError(t, s.Link("new.html", &File{SHA256: other}))
+	_, err = s.Commit()
+	assert.ErrorIs(t, err, ErrMissingFile)
+}
+
+func TestMirror(t *testing.T) {
+	m := NewManager(storage.NewLocal(t.TempDir()), 10)
+
+	s := m.Stage(charmID, false)
+	assert.NoError(t, s.Put("index.html", strings.NewReader("index")))
+	assert.NoError(t, s.Put("old/index.html", strings.NewReader("old")))
+	_, err := s.Commit()
+	assert.NoError(t, err)
+
+	s = m.Stage(charmID, true)
+	assert.NoError(t, s.Put("index.html", strings.NewReader("index")))
+	assert.NoError(t, s.Put("new.html", strings.NewReader("new")))
+	_, err = s.Commit()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"old/index.html"}, s.Deleted())
+
+	assertFile(t, m, "new.html", "new")
+	_, err = m.Stat(charmID, "old/index.html")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+}
+
+// Records the paths written to a storage backend.
+type recordingStore struct {
+	storage.Storage
+	puts []string
+}
+
+func (s *recordingStore) Put(charmID, path string, r io.Reader) error {
+	s.puts = append(s.puts, path)
+	return s.Storage.Put(charmID, path, r)
+}
+
+func TestPutVerified(t *testing.T) {
+	store := &recordingStore{Storage: storage.NewLocal(t.TempDir())}
+	m := NewManager(store, 10)
+
+	h := sha256.Sum256([]byte("index"))
+	sum := hex.EncodeToString(h[:])
+
+	// Content not matching the hash is never written to the blob
+	s := m.Stage(charmID, false)
+	err := s.PutVerified("index.html", sum, strings.NewReader("forged"))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.NotContains(t, store.puts, blobPath(sum))
+	files, err := store.List(charmID)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	// Verified content is moved to the blob, not written again
+	assert.NoError(t, s.PutVerified("index.html", sum, strings.NewReader("index")))
+	assert.NotContains(t, store.puts, blobPath(sum))
+	_, err = store.Stat(charmID, blobPath(sum))
+	assert.NoError(t, err)
+	_, err = s.Commit()
+	assert.NoError(t, err)
+	assertFile(t, m, "index.html", "index")
+}
+
+func TestReadDir(t *testing.T) {
+	m := NewManager(storage.NewLocal(t.TempDir()), 10)
+
+	_, err := m.ReadDir(charmID, "")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	s := m.Stage(charmID, false)
+	assert.NoError(t, s.Put("index.html", strings.NewReader("index")))
+	assert.NoError(t, s.Put("docs/intro.html", strings.NewReader("intro")))
+	assert.NoError(t, s.Put("docs/api/index.html", strings.NewReader("api")))
+	_, err = s.Commit()
+	assert.NoError(t, err)
+
+	names, err := m.ReadDir(charmID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"docs/", "index.html"}, names)
+
+	names, err = m.ReadDir(charmID, "/docs/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"api/", "intro.html"}, names)
+
+	_, err = m.ReadDir(charmID, "doc")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+}
@@ -0,0 +1,242 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rubiojr/tavern/server/storage"
+)
+
+const uploadsDir = "uploads/"
+
+// Resumable uploads not updated in this time are removed
+const uploadExpiry = 24 * time.Hour
+
+// ErrOffsetMismatch is returned when a chunk doesn't start where the
+// previous one ended.
+var ErrOffsetMismatch = errors.New("chunk offset mismatch")
+
+// ErrInvalidChunk is returned for chunks not matching the upload length.
+var ErrInvalidChunk = errors.New("invalid chunk")
+
+// Resumable upload of a blob, stored as a list of chunks until complete.
+type upload struct {
+	Length int64 `json:"length"`
+	// Size of every chunk received, in order
+	Chunks []int64 `json:"chunks"`
+}
+
+func (u *upload) offset() int64 {
+	offset := int64(0)
+	for _, size := range u.Chunks {
+		offset += size
+	}
+
+	return offset
+}
+
+// UploadOffset returns the number of bytes received for the blob with the
+// given hash, and its total length. Both are zero if the upload didn't
+// start yet, and equal for blobs already stored.
+func (m *Manager) UploadOffset(charmID, sum string) (int64, int64, error) {
+	if !validHash(sum) {
+		return 0, 0, fmt.Errorf("invalid SHA-256 hash")
+	}
+
+	if info, err := m.store.Stat(charmID, blobPath(sum)); err == nil {
+		return info.Size, info.Size, nil
+	}
+
+	u, err := m.upload(charmID, sum)
+	if errors.Is(err, storage.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return u.offset(), u.Length, nil
+}
+
+// WriteChunk stores a chunk of the blob with the given hash, starting at
+// offset, and returns the new offset. Once length bytes are received the
+// blob is verified and stored.
+func (m *Manager) WriteChunk(charmID, sum string, offset, length int64, r io.Reader) (int64, error) {
+	if !validHash(sum) {
+		return 0, fmt.Errorf("invalid SHA-256 hash")
+	}
+
+	unlock := m.lock(charmID + "/" + uploadsDir + sum)
+	defer unlock()
+
+	if info, err := m.store.Stat(charmID, blobPath(sum)); err == nil {
+		return info.Size, nil
+	}
+
+	u, err := m.upload(charmID, sum)
+	if errors.Is(err, storage.ErrNotExist) {
+		u, err = &upload{Length: length}, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if u.Length != length {
+		return u.offset(), fmt.Errorf("%w: upload length is %d", ErrInvalidChunk, u.Length)
+	}
+
+	if offset != u.offset() {
+		return u.offset(), ErrOffsetMismatch
+	}
+
+	key := chunkPath(sum, offset)
+	cr := &countingReader{r: io.LimitReader(r, length-offset+1)}
+	err = m.store.Put(charmID, key, cr)
+	if err != nil {
+		return offset, err
+	}
+
+	if offset+cr.n > length {
+		m.store.Delete(charmID, key)
+		return offset, fmt.Errorf("%w: chunk exceeds the upload length", ErrInvalidChunk)
+	}
+
+	// Empty chunks would share the key of the next chunk
+	if cr.n == 0 && offset < length {
+		m.store.Delete(charmID, key)
+		return offset, fmt.Errorf("%w: empty chunk", ErrInvalidChunk)
+	}
+
+	// Chunks are acknowledged once the upload state is saved
+	u.Chunks = append(u.Chunks, cr.n)
+	err = m.saveUpload(charmID, sum, u)
+	if err != nil {
+		return offset, err
+	}
+
+	if u.offset() < length {
+		return u.offset(), nil
+	}
+
+	return length, m.completeUpload(charmID, sum, u)
+}
+
+// Joins the chunks of a complete upload in the blob, if the content
+// matches the hash. The chunks are joined in a temporary file, so the blob
+// is only stored once verified.
+func (m *Manager) completeUpload(charmID, sum string, u *upload) error {
+	// Failed uploads start over
+	defer m.deleteUpload(charmID, sum, u)
+
+	tmp, f, err := m.putTmp(charmID, &chunksReader{m: m, charmID: charmID, sum: sum, chunks: u.Chunks})
+	if err != nil {
+		return err
+	}
+	defer m.store.Delete(charmID, tmp)
+
+	if f.SHA256 != sum {
+		return ErrChecksumMismatch
+	}
+
+	return m.moveBlob(charmID, tmp, sum)
+}
+
+func (m *Manager) upload(charmID, sum string) (*upload, error) {
+	r, err := m.store.Get(charmID, uploadsDir+sum+"/state")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	u := &upload{}
+	err = json.NewDecoder(r).Decode(u)
+	return u, err
+}
+
+func (m *Manager) saveUpload(charmID, sum string, u *upload) error {
+	buf, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	return m.store.Put(charmID, uploadsDir+sum+"/state", bytes.NewReader(buf))
+}
+
+func (m *Manager) deleteUpload(charmID, sum string, u *upload) {
+	offset := int64(0)
+	for _, size := range u.Chunks {
+		m.store.Delete(charmID, chunkPath(sum, offset))
+		offset += size
+	}
+	m.store.Delete(charmID, uploadsDir+sum+"/state")
+}
+
+// Removes the uploads not updated in uploadExpiry.
+func (m *Manager) expireUploads(charmID string, files []*storage.FileInfo) error {
+	for _, f := range files {
+		if !strings.HasPrefix(f.Path, uploadsDir) || !strings.HasSuffix(f.Path, "/state") {
+			continue
+		}
+		if time.Since(f.ModTime) < uploadExpiry {
+			continue
+		}
+
+		sum := strings.TrimSuffix(strings.TrimPrefix(f.Path, uploadsDir), "/state")
+		u, err := m.upload(charmID, sum)
+		if err != nil {
+			return err
+		}
+		m.deleteUpload(charmID, sum, u)
+	}
+
+	return nil
+}
+
+func chunkPath(sum string, offset int64) string {
+	return fmt.Sprintf("%s%s/%020d", uploadsDir, sum, offset)
+}
+
+// Reads the chunks of an upload in order.
+type chunksReader struct {
+	m       *Manager
+	charmID string
+	sum     string
+	chunks  []int64
+	offset  int64
+	current io.ReadCloser
+}
+
+func (c *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.chunks) == 0 {
+				return 0, io.EOF
+			}
+
+			r, err := c.m.store.Get(c.charmID, chunkPath(c.sum, c.offset))
+			if err != nil {
+				return 0, err
+			}
+			c.current = r
+			c.offset += c.chunks[0]
+			c.chunks = c.chunks[1:]
+		}
+
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
@@ -0,0 +1,76 @@
+package deploy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rubiojr/tavern/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteChunk(t *testing.T) {
+	store := &recordingStore{Storage: storage.NewLocal(t.TempDir())}
+	m := NewManager(store, 10)
+
+	h := sha256.Sum256([]byte("0123456789"))
+	sum := hex.EncodeToString(h[:])
+
+	offset, err := m.WriteChunk(charmID, sum, 0, 10, strings.NewReader("0123"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), offset)
+
+	// Empty chunks are rejected, and don't change the upload
+	offset, err = m.WriteChunk(charmID, sum, 4, 10, strings.NewReader(""))
+	assert.ErrorIs(t, err, ErrInvalidChunk)
+	assert.Equal(t, int64(4), offset)
+	offset, length, err := m.UploadOffset(charmID, sum)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), offset)
+	assert.Equal(t, int64(10), length)
+
+	offset, err = m.WriteChunk(charmID, sum, 4, 10, strings.NewReader("456789"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), offset)
+
+	s := m.Stage(charmID, false)
+	assert.NoError(t, s.Link("index.html", &File{SHA256: sum, Size: 10}))
+	_, err = s.Commit()
+	assert.NoError(t, err)
+	assertFile(t, m, "index.html", "0123456789")
+
+	// Uploads not matching the hash are never written to the blob
+	h = sha256.Sum256([]byte("other"))
+	other := hex.EncodeToString(h[:])
+	_, err = m.WriteChunk(charmID, other, 0, 6, strings.NewReader("forged"))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.NotContains(t, store.puts, blobPath(other))
+	offset, _, err = m.UploadOffset(charmID, other)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+
+	// Locks of finished uploads are not kept
+	assert.Empty(t, m.locks)
+}
+
+func TestLock(t *testing.T) {
+	m := NewManager(storage.NewLocal(t.TempDir()), 10)
+
+	unlock := m.lock("key")
+	locked := make(chan struct{})
+	go func() {
+		m.lock("key")()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		assert.FailNow(t, "lock held twice")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+	assert.Empty(t, m.locks)
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rubiojr/tavern/internal/deploy"
+)
+
+// Returns the number of bytes received for a resumable upload in the
+// Upload-Offset header, and its length in Upload-Length once started.
+func UploadOffset(deployments *deploy.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		site, ok := c.Value("site").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "site not found"})
+			return
+		}
+
+		offset, length, err := deployments.UploadOffset(site, c.Param("sha256"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		if length > 0 {
+			c.Header("Upload-Length", strconv.FormatInt(length, 10))
+		}
+		c.Status(http.StatusOK)
+	}
+}
+
+// Receives a chunk of a resumable upload, for the file with the SHA-256
+// hash in the path.
+//
+// The Upload-Offset header must match the bytes received so far, and
+// Upload-Length the size of the file. The new offset is returned in the
+// Upload-Offset header.
+func UploadChunk(deployments *deploy.Manager, maxFileSize, maxUploadSize int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		site, ok := c.Value("site").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "site not found"})
+			return
+		}
+
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Offset header"})
+			return
+		}
+
+		length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+		if err != nil || length < 1 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid Upload-Length header"})
+			return
+		}
+
+		if maxFileSize > 0 && length > maxFileSize {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": (&sizeLimitError{"file", maxFileSize}).Error()})
+			return
+		}
+
+		body := c.Request.Body
+		if maxUploadSize > 0 {
+			body = &limitedReader{ReadCloser: body, n: maxUploadSize, err: &sizeLimitError{"upload", maxUploadSize}}
+		}
+
+		offset, err = deployments.WriteChunk(site, c.Param("sha256"), offset, length, body)
+		c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		var serr *sizeLimitError
+		switch {
+		case errors.As(err, &serr):
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": serr.Error()})
+		case errors.Is(err, deploy.ErrOffsetMismatch):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, deploy.ErrInvalidChunk), errors.Is(err, deploy.ErrChecksumMismatch):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		default:
+			c.Status(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lists of the Charm IDs allowed and denied to publish.
+//
+// Besides the IDs given when created, IDs can be listed in a file, one per
+// line, with denied IDs prefixed with "!" and comments starting with "#".
+// The file is read again when it changes.
+type CharmIDs struct {
+	allowed map[string]struct{}
+	denied  map[string]struct{}
+	file    string
+
+	mu          sync.Mutex
+	fileAllowed map[string]struct{}
+	fileDenied  map[string]struct{}
+	modTime     time.Time
+	size        int64
+}
+
+// Returns the lists of allowed and denied Charm IDs, reading the IDs in
+// file if not empty.
+func NewCharmIDs(allowed, denied []string, file string) (*CharmIDs, error) {
+	ids := &CharmIDs{allowed: idSet(allowed), denied: idSet(denied), file: file}
+	if file == "" {
+		return ids, nil
+	}
+
+	err := ids.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Allowed reports whether charmID can publish: it's not denied, and it's
+// allowed or no IDs are allowed explicitly.
+func (ids *CharmIDs) Allowed(charmID string) bool {
+	if ids == nil {
+		return true
+	}
+
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	if ids.file != "" {
+		err := ids.reload()
+		if err != nil {
+			log.Printf("reading %s failed, using the previous Charm IDs: %s", ids.file, err)
+		}
+	}
+
+	if _, ok := ids.denied[charmID]; ok {
+		return false
+	}
+	if _, ok := ids.fileDenied[charmID]; ok {
+		return false
+	}
+
+	if len(ids.allowed) == 0 && len(ids.fileAllowed) == 0 {
+		return true
+	}
+	_, ok := ids.allowed[charmID]
+	if !ok {
+		_, ok = ids.fileAllowed[charmID]
+	}
+
+	return ok
+}
+
+// Reads the Charm IDs file if it changed since it was last read.
+func (ids *CharmIDs) reload() error {
+	info, err := os.Stat(ids.file)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(ids.modTime) && info.Size() == ids.size {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(ids.file)
+	if err != nil {
+		return err
+	}
+
+	allowed, denied := map[string]struct{}{}, map[string]struct{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		set := allowed
+		if strings.HasPrefix(line, "!") {
+			set = denied
+			line = strings.TrimSpace(line[1:])
+		}
+		if line == "" || strings.ContainsAny(line, " \t") {
+			return fmt.Errorf("%s:%d: invalid Charm ID", ids.file, n)
+		}
+		set[line] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	ids.fileAllowed, ids.fileDenied = allowed, denied
+	ids.modTime, ids.size = info.ModTime(), info.Size()
+	return nil
+}
+
+func idSet(ids []string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+
+	return set
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCharmIDs(t *testing.T) {
+	const other = "0a1b2c3d-0000-4000-8000-000000000000"
+
+	var ids *CharmIDs
+	assert.True(t, ids.Allowed(charmID), "nil allows any Charm ID")
+
+	ids, err := NewCharmIDs(nil, []string{other}, "")
+	assert.NoError(t, err)
+	assert.True(t, ids.Allowed(charmID))
+	assert.False(t, ids.Allowed(other))
+
+	ids, err = NewCharmIDs([]string{charmID, other}, []string{other}, "")
+	assert.NoError(t, err)
+	assert.True(t, ids.Allowed(charmID))
+	assert.False(t, ids.Allowed(other), "denied IDs win")
+	assert.False(t, ids.Allowed("unknown"))
+
+	file := filepath.Join(t.TempDir(), "charm-ids")
+	_, err = NewCharmIDs(nil, nil, file)
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte("# publishers\n"+charmID+"\n"), 0644))
+	ids, err = NewCharmIDs(nil, nil, file)
+	assert.NoError(t, err)
+	assert.True(t, ids.Allowed(charmID))
+	assert.False(t, ids.Allowed(other))
+
+	// The file is read again when it changes
+	assert.NoError(t, ioutil.WriteFile(file, []byte("!"+charmID+"\n"), 0644))
+	touch(t, file, time.Minute)
+	assert.False(t, ids.Allowed(charmID))
+	assert.True(t, ids.Allowed(other))
+
+	// Invalid files are ignored, keeping the previous IDs
+	assert.NoError(t, ioutil.WriteFile(file, []byte("an invalid ID\n"), 0644))
+	touch(t, file, 2*time.Minute)
+	assert.False(t, ids.Allowed(charmID))
+	assert.True(t, ids.Allowed(other))
+}
+
+// Bumps the modification time of file, in case it was written within the
+// file system timestamp resolution.
+func touch(t *testing.T, file string, d time.Duration) {
+	t.Helper()
+
+	mtime := time.Now().Add(d)
+	assert.NoError(t, os.Chtimes(file, mtime, mtime))
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rubiojr/tavern/internal/deploy"
+	"github.com/rubiojr/tavern/server/storage"
+)
+
+// Lists the deployments of the authenticated Charm ID, newest first.
+func Deployments(deployments *deploy.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		site, ok := c.Value("site").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "site not found"})
+			return
+		}
+
+		infos, err := deployments.List(site)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"deployments": infos})
+	}
+}
+
+type rollbackRequest struct {
+	ID string `json:"id"`
+}
+
+// Makes a previous deployment live again.
+//
+// Rolls back to the deployment with the ID in the request body, or to the
+// deployment created before the live one if no ID is given.
+func Rollback(deployments *deploy.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		site, ok := c.Value("site").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "site not found"})
+			return
+		}
+
+		req := &rollbackRequest{}
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(req); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+				return
+			}
+		}
+
+		d, err := deployments.Rollback(site, req.ID)
+		switch {
+		case errors.Is(err, storage.ErrNotExist):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "deployment not found"})
+			return
+		case errors.Is(err, deploy.ErrNoPrevious):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		info := d.Info()
+		info.Live = true
+		c.JSON(http.StatusOK, gin.H{"deployment": info})
+	}
+}
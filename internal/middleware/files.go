@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rubiojr/tavern/internal/deploy"
+	"github.com/rubiojr/tavern/internal/sites"
+	"github.com/rubiojr/tavern/server/storage"
+)
+
+const indexFile = "index.html"
+
+// Serves the files in the live deployment of published sites.
+//
+// Requests are mapped to site paths as /<charm-id>/<path> for the default
+// site of a Charm ID, /<charm-id>~<site>/<path> for its named sites, or
+// /<site-name>/<path> for sites with a name in registry. Paths ending
+// with a slash serve the index.html file in that directory, or list the
+// directory if it doesn't have one. The root of the server is not listed,
+// as it would list the published Charm IDs.
+func Files(deployments *deploy.Manager, registry *sites.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		upath := path.Clean("/" + c.Request.URL.Path)
+		if strings.HasSuffix(c.Request.URL.Path, "/") && upath != "/" {
+			upath += "/"
+		}
+		parts := strings.SplitN(strings.TrimPrefix(upath, "/"), "/", 2)
+		charmID := parts[0]
+		if charmID == "" || storage.Reserved(charmID) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		site, err := registry.Lookup(charmID)
+		if err == nil {
+			charmID = site
+		} else if !errors.Is(err, storage.ErrNotExist) {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+
+		fpath := ""
+		if len(parts) > 1 {
+			fpath = parts[1]
+		}
+
+		serveFile(c, deployments, charmID, fpath)
+	}
+}
+
+func serveFile(c *gin.Context, deployments *deploy.Manager, charmID, fpath string) {
+	name := fpath
+	isDir := name == "" || strings.HasSuffix(name, "/")
+	if isDir {
+		name += indexFile
+	}
+
+	_, err := deployments.Stat(charmID, name)
+	if errors.Is(err, storage.ErrNotExist) && !isDir {
+		// Redirect to the directory if it exists, like http.FileServer does
+		if _, err := deployments.ReadDir(charmID, fpath); err == nil {
+			c.Redirect(http.StatusMovedPermanently, c.Request.URL.Path+"/")
+			return
+		}
+	}
+	if errors.Is(err, storage.ErrNotExist) && isDir {
+		// Directories without an index file are listed
+		names, err := deployments.ReadDir(charmID, strings.TrimSuffix(fpath, "/"))
+		if err != nil {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		if fpath == "" && !strings.HasSuffix(c.Request.URL.Path, "/") {
+			c.Redirect(http.StatusMovedPermanently, c.Request.URL.Path+"/")
+			return
+		}
+		listDir(c, names)
+		return
+	}
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	if fpath == "" && !strings.HasSuffix(c.Request.URL.Path, "/") {
+		c.Redirect(http.StatusMovedPermanently, c.Request.URL.Path+"/")
+		return
+	}
+
+	f, info, err := deployments.Open(charmID, name)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, name, info.ModTime, rs)
+		return
+	}
+
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		c.Header("Content-Type", ctype)
+	}
+	c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	c.Header("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+	io.Copy(c.Writer, f)
+}
+
+// Writes a listing of the directory entries in names, like the one
+// http.FileServer serves.
+func listDir(c *gin.Context, names []string) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+
+	fmt.Fprintf(c.Writer, "<pre>\n")
+	for _, name := range names {
+		u := url.URL{Path: name}
+		fmt.Fprintf(c.Writer, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(name))
+	}
+	fmt.Fprintf(c.Writer, "</pre>\n")
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rubiojr/tavern/internal/deploy"
+	"github.com/rubiojr/tavern/internal/sites"
+	"github.com/rubiojr/tavern/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFiles(t *testing.T) {
+	store := storage.NewLocal(t.TempDir())
+	deployments := deploy.NewManager(store, 10)
+	s := deployments.Stage(charmID, false)
+	assert.NoError(t, s.Put("index.html", strings.NewReader("index")))
+	assert.NoError(t, s.Put("docs/index.html", strings.NewReader("docs")))
+	assert.NoError(t, s.Put("images/logo.png", strings.NewReader("logo")))
+	_, err := s.Commit()
+	assert.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.NoRoute(Files(deployments, sites.NewRegistry(store)))
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/" + charmID + "/", http.StatusOK, "index"},
+		{"/" + charmID, http.StatusMovedPermanently, ""},
+		{"/" + charmID + "/docs/", http.StatusOK, "docs"},
+		{"/" + charmID + "/docs", http.StatusMovedPermanently, ""},
+		{"/" + charmID + "/images/logo.png", http.StatusOK, "logo"},
+		// Directories without an index file are listed
+		{"/" + charmID + "/images/", http.StatusOK, "<pre>\n<a href=\"logo.png\">logo.png</a>\n</pre>\n"},
+		{"/" + charmID + "/images", http.StatusMovedPermanently, ""},
+		{"/" + charmID + "/missing/", http.StatusNotFound, ""},
+		{"/", http.StatusNotFound, ""},
+		{"/" + charmID + "/missing.html", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		assert.Equal(t, tt.status, w.Code, tt.path)
+		if tt.body != "" {
+			assert.Equal(t, tt.body, w.Body.String(), tt.path)
+		}
+	}
+}
@@ -1,25 +1,28 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
-	"time"
+	"strings"
 
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
-	"github.com/auth0/go-jwt-middleware/v2/jwks"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/rubiojr/tavern/server/storage"
 )
 
 // Accepts a list of Charm server hosts allowed for publishing in this
 // Tavern instance.
 // If the list is empty, any charm host is allowed.
-func JWKS(allowedServers map[string]struct{}) gin.HandlerFunc {
+// Charm IDs not allowed by ids can't publish, nil allows any Charm ID.
+// Tokens are validated with the Charm server keys in keys.
+func JWKS(allowedServers map[string]struct{}, ids *CharmIDs, keys *KeyCache) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		claims, err := getClaims(c.GetHeader("Authorization"))
+		claims, kid, err := getClaims(c.GetHeader("Authorization"))
 		if err != nil {
 			log.Printf("JWT parsing error: %s", err)
 			c.AbortWithStatus(http.StatusUnauthorized)
@@ -33,12 +36,6 @@ func JWKS(allowedServers map[string]struct{}) gin.HandlerFunc {
 			return
 		}
 
-		if claims.Subject == "" {
-			log.Printf("invalid CharmID found: %s", err)
-			c.AbortWithStatus(http.StatusUnauthorized)
-		}
-		c.Set("charm_id", claims.Subject)
-
 		if len(allowedServers) > 0 {
 			if _, ok := allowedServers[issuer.Hostname()]; !ok {
 				log.Printf("err: Charm server '%s' not accepted", issuer.Hostname())
@@ -47,9 +44,11 @@ func JWKS(allowedServers map[string]struct{}) gin.HandlerFunc {
 			}
 		}
 
-		p := jwks.NewCachingProvider(issuer, 1*time.Hour)
+		keyFunc := func(ctx context.Context) (interface{}, error) {
+			return keys.Keys(ctx, issuer, kid)
+		}
 		jwtValidator, err := validator.New(
-			p.KeyFunc,
+			keyFunc,
 			validator.EdDSA,
 			issuer.String(),
 			[]string{"tavern"},
@@ -60,32 +59,55 @@ func JWKS(allowedServers map[string]struct{}) gin.HandlerFunc {
 			return
 		}
 
-		valid := false
+		var validated *validator.ValidatedClaims
 		var handler http.HandlerFunc
-		handler = func(http.ResponseWriter, *http.Request) { valid = true }
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			validated, _ = r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+		}
 		middleware := jwtmiddleware.New(jwtValidator.ValidateToken)
 		middleware.CheckJWT(handler).ServeHTTP(c.Writer, c.Request)
-		if valid {
-			c.Next()
-		} else {
+		if validated == nil {
 			log.Printf("JWT validation failed. Issuer: %s", issuer.Hostname())
 			c.Abort()
+			return
+		}
+
+		// Only claims in tokens with a valid signature are trusted.
+		// Reserved namespaces hold the server's own data, and named sites
+		// are namespaced as <charm-id>~<site>
+		subject := validated.RegisteredClaims.Subject
+		if subject == "" || storage.Reserved(subject) || strings.Contains(subject, storage.SiteSeparator) {
+			log.Printf("invalid CharmID found: %q", subject)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
 		}
+
+		if !ids.Allowed(subject) {
+			log.Printf("err: Charm ID '%s' not accepted", subject)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("charm ID %s cannot publish", subject)})
+			return
+		}
+
+		c.Set("charm_id", subject)
+		c.Next()
 	}
 }
 
-func getClaims(auth string) (*jwt.RegisteredClaims, error) {
+// Returns the claims of the token in the Authorization header, and the ID
+// of the key that signed it.
+func getClaims(auth string) (*jwt.RegisteredClaims, string, error) {
 	tMinLen := len("Bearer ")
 	if len(auth) <= tMinLen {
-		return nil, fmt.Errorf("invalid header token")
+		return nil, "", fmt.Errorf("invalid header token")
 	}
 
 	encodedToken := auth[tMinLen:]
 	p := jwt.Parser{}
 	t, _, err := p.ParseUnverified(encodedToken, &jwt.RegisteredClaims{})
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return t.Claims.(*jwt.RegisteredClaims), nil
+	kid, _ := t.Header["kid"].(string)
+	return t.Claims.(*jwt.RegisteredClaims), kid, nil
 }
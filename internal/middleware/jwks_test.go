@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestJWKSCharmIDs(t *testing.T) {
+	const denied = "0a1b2c3d-0000-4000-8000-000000000000"
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+	keys := NewKeyCache(1)
+	keys.fetch = func(ctx context.Context, issuer *url.URL) (*jose.JSONWebKeySet, error) {
+		return &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: pub, KeyID: "test", Algorithm: "EdDSA"}}}, nil
+	}
+	ids, err := NewCharmIDs(nil, []string{denied}, "")
+	assert.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", JWKS(nil, ids, keys), func(c *gin.Context) {
+		c.String(http.StatusOK, c.Value("charm_id").(string))
+	})
+
+	_, forger, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	tests := []struct {
+		name    string
+		charmID string
+		method  jwt.SigningMethod
+		key     interface{}
+		status  int
+		body    string
+	}{
+		{"allowed", charmID, &jwt.SigningMethodEd25519{}, priv, http.StatusOK, charmID},
+		{"denied", denied, &jwt.SigningMethodEd25519{}, priv, http.StatusUnauthorized, `{"error":"charm ID ` + denied + ` cannot publish"}`},
+		// Forged tokens don't tell whether a Charm ID is allowed
+		{"forged denied", denied, &jwt.SigningMethodEd25519{}, forger, http.StatusUnauthorized, ""},
+		{"forged allowed", charmID, &jwt.SigningMethodEd25519{}, forger, http.StatusUnauthorized, ""},
+		{"unsigned denied", denied, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, http.StatusUnauthorized, ""},
+	}
+	var generic string
+	for _, tt := range tests {
+		token := jwt.NewWithClaims(tt.method, &jwt.RegisteredClaims{
+			Issuer:    "http://charm.example.com",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Audience:  []string{"tavern"},
+			Subject:   tt.charmID,
+		})
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(tt.key)
+		if err != nil {
+			assert.FailNow(t, err.Error())
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signed)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tt.status, w.Code, tt.name)
+		if tt.body != "" {
+			assert.Equal(t, tt.body, w.Body.String(), tt.name)
+			continue
+		}
+
+		// Invalid tokens get the same response, whatever their subject
+		assert.NotContains(t, w.Body.String(), "cannot publish", tt.name)
+		if generic == "" {
+			generic = w.Body.String()
+		}
+		assert.Equal(t, generic, w.Body.String(), tt.name)
+	}
+}
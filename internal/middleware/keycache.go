@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/auth0/go-jwt-middleware/v2/jwks"
+	"golang.org/x/sync/singleflight"
+	"gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// Issuers whose keys are cached by default
+	DefaultKeyCacheSize = 100
+	// Time keys are cached before fetching them again
+	keyCacheTTL = time.Hour
+	// Expired keys are still used for this long if the issuer can't be
+	// reached, so publishing keeps working while a Charm server is down
+	keyCacheStale = time.Hour
+	// Unknown key IDs refresh the keys of an issuer at most this often, and
+	// issuers that failed to return their keys are retried after this long
+	keyCacheMinRefresh = 10 * time.Second
+	keyFetchTimeout    = 10 * time.Second
+)
+
+// Cache of the JSON Web Key Sets of the Charm servers issuing the tokens
+// used to publish, shared by every request. Keys are fetched again when they
+// expire or when a token is signed with an unknown key, and concurrent
+// fetches for the same issuer are coalesced.
+type KeyCache struct {
+	size    int
+	mu      sync.Mutex
+	entries map[string]*keyCacheEntry
+	group   singleflight.Group
+	fetch   func(ctx context.Context, issuer *url.URL) (*jose.JSONWebKeySet, error)
+}
+
+type keyCacheEntry struct {
+	keys    *jose.JSONWebKeySet
+	fetched time.Time
+	used    time.Time
+	// Last time fetching the keys failed
+	failed time.Time
+}
+
+// Returns a cache keeping the keys of up to size issuers.
+func NewKeyCache(size int) *KeyCache {
+	if size < 1 {
+		size = DefaultKeyCacheSize
+	}
+
+	return &KeyCache{size: size, entries: map[string]*keyCacheEntry{}, fetch: fetchKeys}
+}
+
+func fetchKeys(ctx context.Context, issuer *url.URL) (*jose.JSONWebKeySet, error) {
+	p := jwks.NewProvider(issuer, jwks.WithCustomClient(&http.Client{Timeout: keyFetchTimeout}))
+	keys, err := p.KeyFunc(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys.(*jose.JSONWebKeySet), nil
+}
+
+// Returns the keys of issuer, fetching them if they aren't cached, have
+// expired, or don't include the key kid.
+func (kc *KeyCache) Keys(ctx context.Context, issuer *url.URL, kid string) (*jose.JSONWebKeySet, error) {
+	iss := issuer.String()
+	now := time.Now()
+
+	kc.mu.Lock()
+	e := kc.entries[iss]
+	if e != nil {
+		e.used = now
+		fresh := now.Sub(e.fetched) < keyCacheTTL
+		known := kid == "" || len(e.keys.Key(kid)) > 0
+		if fresh && (known || now.Sub(e.fetched) < keyCacheMinRefresh) {
+			kc.mu.Unlock()
+			return e.keys, nil
+		}
+		// Requests don't wait for an issuer that just failed, the cached
+		// keys are used until it's retried
+		if now.Sub(e.failed) < keyCacheMinRefresh && now.Sub(e.fetched) < keyCacheTTL+keyCacheStale {
+			kc.mu.Unlock()
+			return e.keys, nil
+		}
+	}
+	kc.mu.Unlock()
+
+	v, err, _ := kc.group.Do(iss, func() (interface{}, error) {
+		// Not bound to the request context, other requests may be waiting
+		// for the keys
+		ctx, cancel := context.WithTimeout(context.Background(), keyFetchTimeout)
+		defer cancel()
+		return kc.fetch(ctx, issuer)
+	})
+	if err != nil {
+		kc.mu.Lock()
+		if e != nil {
+			e.failed = time.Now()
+		}
+		kc.mu.Unlock()
+		if e != nil && time.Since(e.fetched) < keyCacheTTL+keyCacheStale {
+			log.Printf("fetching keys from %s failed, using cached keys: %s", iss, err)
+			return e.keys, nil
+		}
+		return nil, err
+	}
+
+	keys := v.(*jose.JSONWebKeySet)
+	kc.put(iss, keys)
+
+	return keys, nil
+}
+
+// Caches the keys of issuer, evicting the least recently used issuer if the
+// cache is full.
+func (kc *KeyCache) put(iss string, keys *jose.JSONWebKeySet) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := kc.entries[iss]; ok {
+		e.keys, e.fetched, e.used = keys, now, now
+		return
+	}
+
+	if len(kc.entries) >= kc.size {
+		var oldest string
+		for k, e := range kc.entries {
+			if oldest == "" || e.used.Before(kc.entries[oldest].used) {
+				oldest = k
+			}
+		}
+		delete(kc.entries, oldest)
+	}
+	kc.entries[iss] = &keyCacheEntry{keys: keys, fetched: now, used: now}
+}
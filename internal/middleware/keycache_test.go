@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestKeyCache(t *testing.T) {
+	var fetches int32
+	var fail atomic.Value
+	fail.Store(false)
+	release := make(chan struct{})
+	close(release)
+	kid := "key1"
+
+	kc := NewKeyCache(2)
+	kc.fetch = func(ctx context.Context, issuer *url.URL) (*jose.JSONWebKeySet, error) {
+		<-release
+		atomic.AddInt32(&fetches, 1)
+		if fail.Load().(bool) {
+			return nil, errors.New("charm server down")
+		}
+		return &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{KeyID: kid}}}, nil
+	}
+	issuer, _ := url.Parse("https://charm.example.com")
+
+	keys, err := kc.Keys(context.Background(), issuer, "key1")
+	assert.NoError(t, err)
+	assert.Len(t, keys.Key("key1"), 1)
+	_, err = kc.Keys(context.Background(), issuer, "key1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "keys are cached")
+
+	t.Run("unknown key IDs refresh the keys", func(t *testing.T) {
+		atomic.StoreInt32(&fetches, 0)
+		_, err := kc.Keys(context.Background(), issuer, "key2")
+		assert.NoError(t, err)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&fetches), "refreshed too soon")
+
+		kc.entries[issuer.String()].fetched = time.Now().Add(-keyCacheMinRefresh)
+		kid = "key2"
+		keys, err := kc.Keys(context.Background(), issuer, "key2")
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+		assert.Len(t, keys.Key("key2"), 1)
+	})
+
+	t.Run("concurrent fetches are coalesced", func(t *testing.T) {
+		atomic.StoreInt32(&fetches, 0)
+		release = make(chan struct{})
+		other, _ := url.Parse("https://other.example.com")
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := kc.Keys(context.Background(), other, "key2")
+				assert.NoError(t, err)
+			}()
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	})
+
+	t.Run("expired keys are used while the issuer is down", func(t *testing.T) {
+		fail.Store(true)
+		defer fail.Store(false)
+
+		atomic.StoreInt32(&fetches, 0)
+		kc.entries[issuer.String()].fetched = time.Now().Add(-keyCacheTTL)
+		_, err := kc.Keys(context.Background(), issuer, "key2")
+		assert.NoError(t, err)
+		_, err = kc.Keys(context.Background(), issuer, "key2")
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "failed issuers are retried too soon")
+
+		kc.entries[issuer.String()].fetched = time.Now().Add(-keyCacheTTL - keyCacheStale)
+		_, err = kc.Keys(context.Background(), issuer, "key2")
+		assert.Error(t, err)
+	})
+
+	t.Run("bounded size", func(t *testing.T) {
+		third, _ := url.Parse("https://third.example.com")
+		_, err := kc.Keys(context.Background(), third, "key2")
+		assert.NoError(t, err)
+		assert.Len(t, kc.entries, 2)
+		assert.Contains(t, kc.entries, third.String())
+	})
+}
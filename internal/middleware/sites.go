@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rubiojr/tavern/internal/sites"
+	"github.com/rubiojr/tavern/server/storage"
+)
+
+type claimSiteRequest struct {
+	Name string `json:"name"`
+}
+
+// Sets the storage namespace of the site requests act on as "site": the
+// site named in the site query parameter, or the default site of the
+// authenticated Charm ID. Requests authenticated with a deploy token act on
+// the site of the token, and can't name a different one.
+func Site() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		charmID, ok := c.Value("charm_id").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "charm_id not found"})
+			return
+		}
+
+		site, err := storage.SiteNamespace(charmID, c.Query("site"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if scoped, ok := c.Value("site").(string); ok {
+			if c.Query("site") != "" && site != scoped {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("deploy token cannot publish to site %s", c.Query("site"))})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		c.Set("site", site)
+		c.Next()
+	}
+}
+
+// Claims the site name in the request body for the site of the request.
+func ClaimSite(registry *sites.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		charmID, ok := c.Value("charm_id").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "charm_id not found"})
+			return
+		}
+
+		site, ok := c.Value("site").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "site not found"})
+			return
+		}
+
+		req := &claimSiteRequest{}
+		if err := c.ShouldBindJSON(req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+			return
+		}
+
+		claimed, err := registry.Claim(charmID, site, req.Name)
+		switch {
+		case errors.Is(err, sites.ErrTaken), errors.Is(err, sites.ErrReserved):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"site": claimed})
+	}
+}
+
+// Lists the site names of the authenticated Charm ID.
+func Sites(registry *sites.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		charmID, ok := c.Value("charm_id").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "charm_id not found"})
+			return
+		}
+
+		list, err := registry.List(charmID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"sites": list})
+	}
+}
+
+// Releases the site name in the request path, so others can claim it.
+func ReleaseSite(registry *sites.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		charmID, ok := c.Value("charm_id").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "charm_id not found"})
+			return
+		}
+
+		site, err := registry.Release(charmID, c.Param("name"))
+		switch {
+		case errors.Is(err, storage.ErrNotExist):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "site not found"})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"site": site})
+	}
+}
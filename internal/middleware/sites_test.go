@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSite(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		query  string
+		scoped string
+		status int
+		site   string
+	}{
+		{"default site", "", "", http.StatusOK, charmID},
+		{"named site", "?site=blog", "", http.StatusOK, charmID + "~blog"},
+		{"invalid site", "?site=../blog", "", http.StatusBadRequest, ""},
+		{"token site", "", charmID + "~blog", http.StatusOK, charmID + "~blog"},
+		{"token naming its site", "?site=blog", charmID + "~blog", http.StatusOK, charmID + "~blog"},
+		{"token naming another site", "?site=docs", charmID + "~blog", http.StatusForbidden, ""},
+		{"token naming the default site", "?site=blog", charmID, http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/", func(c *gin.Context) {
+				c.Set("charm_id", charmID)
+				if tt.scoped != "" {
+					c.Set("site", tt.scoped)
+				}
+			}, Site(), func(c *gin.Context) {
+				c.String(http.StatusOK, c.Value("site").(string))
+			})
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+tt.query, nil))
+
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.site != "" {
+				assert.Equal(t, tt.site, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rubiojr/tavern/internal/tokens"
+	"github.com/rubiojr/tavern/server/storage"
+)
+
+// Authenticates requests with deploy tokens, falling back to jwks for
+// requests authenticated with Charm JWTs.
+//
+// Requests with a valid deploy token act on the site the token was
+// issued for, if the Charm ID that created the token is allowed by ids.
+// Use Site after DeployTokens, to set the site of requests authenticated
+// with Charm JWTs.
+func DeployTokens(store *tokens.Store, ids *CharmIDs, jwks gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth := c.GetHeader("Authorization")
+		if len(auth) <= len("Bearer ") || !strings.HasPrefix(auth[len("Bearer "):], tokens.Prefix) {
+			jwks(c)
+			return
+		}
+
+		t, err := store.Lookup(auth[len("Bearer "):])
+		if errors.Is(err, tokens.ErrInvalid) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("deploy token lookup failed: %s", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		if !ids.Allowed(t.CharmID) {
+			log.Printf("err: Charm ID '%s' not accepted", t.CharmID)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("charm ID %s cannot publish", t.CharmID)})
+			return
+		}
+
+		c.Set("charm_id", t.CharmID)
+		c.Set("site", t.Site)
+		c.Set("deploy_token", t)
+		c.Next()
+	}
+}
+
+type createTokenRequest struct {
+	Name    string    `json:"name"`
+	Expires time.Time `json:"expires"`
+}
+
+// Issues a deploy token publishing to the site of the request. The response
+// has the token secret, only returned once.
+func CreateToken(store *tokens.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		charmID, ok := c.Value("charm_id").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "charm_id not found"})
+			return
+		}
+
+		site, ok := c.Value("site").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "site not found"})
+			return
+		}
+
+		req := &createTokenRequest{}
+		if err := c.ShouldBindJSON(req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+			return
+		}
+
+		t, secret, err := store.Create(charmID, site, req.Name, req.Expires)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"token": t, "secret": secret})
+	}
+}
+
+// Lists the deploy tokens of the authenticated Charm ID.
+func Tokens(store *tokens.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		charmID, ok := c.Value("charm_id").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "charm_id not found"})
+			return
+		}
+
+		list, err := store.List(charmID)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tokens": list})
+	}
+}
+
+// Revokes the deploy token with the ID in the request path.
+func RevokeToken(store *tokens.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		charmID, ok := c.Value("charm_id").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "charm_id not found"})
+			return
+		}
+
+		t, err := store.Revoke(charmID, c.Param("id"))
+		switch {
+		case errors.Is(err, storage.ErrNotExist):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "token not found"})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"token": t})
+	}
+}
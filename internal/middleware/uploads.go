@@ -1,93 +1,275 @@
 package middleware
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 
+	"github.com/dustin/go-humanize"
 	"github.com/gin-gonic/gin"
+	"github.com/rubiojr/tavern/internal/deploy"
+	"github.com/rubiojr/tavern/internal/sites"
 )
 
+// Maximum size of the manifest in upload forms
+const maxManifestSize = 32 << 20
+
 type HTTPUploads struct {
-	dir string
-	mem int64
+	deployments *deploy.Manager
+	registry    *sites.Registry
+	site        string
+	// Size limits in bytes, zero for no limit
+	maxFileSize   int64
+	maxUploadSize int64
 }
 
-func Uploads(dir string, memLimit int64) gin.HandlerFunc {
+// Publishes the uploaded files as a new deployment, made live only after
+// every file in the request has been stored.
+//
+// Uploads larger than maxUploadSize bytes, or with files larger than
+// maxFileSize bytes, are rejected. Zero means no limit.
+//
+// The response has the path of the site, its name in registry if it has
+// one or the Charm ID otherwise.
+func Uploads(deployments *deploy.Manager, registry *sites.Registry, maxFileSize, maxUploadSize int64) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		charmID, ok := c.Value("charm_id").(string)
+		site, ok := c.Value("site").(string)
 		if !ok {
-			c.String(http.StatusBadRequest, "charm_id not found")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "site not found"})
 			return
 		}
-		tdir := filepath.Join(dir, charmID)
-		handler := &HTTPUploads{tdir, memLimit}
+		handler := &HTTPUploads{deployments, registry, site, maxFileSize, maxUploadSize}
 		handler.ServeHTTP(c.Writer, c.Request)
 	}
 }
 
-func (m *HTTPUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	r.ParseMultipartForm(m.mem)
-	if r.MultipartForm == nil || r.MultipartForm.File["upload[]"] == nil {
-		http.Error(w, "no files found in request", http.StatusBadRequest)
-		return
-	}
-
-	files := r.MultipartForm.File["upload[]"]
-	for _, fileHeader := range files {
-		_, params, err := mime.ParseMediaType(fileHeader.Header.Get("Content-Disposition"))
-		if err != nil {
-			renderError(w, err, "error opening data", http.StatusBadRequest)
+// Returns the paths in the manifest sent by the client with content not
+// stored in the server, so only those are uploaded.
+func Manifest(deployments *deploy.Manager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		site, ok := c.Value("site").(string)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "site not found"})
 			return
 		}
 
-		dfile := filepath.Join(m.dir, params["filename"])
-		ddir := filepath.Dir(dfile)
-
-		file, err := fileHeader.Open()
-		if err != nil {
-			renderError(w, err, "error opening data", http.StatusBadRequest)
+		manifest := &deploy.Manifest{}
+		if err := c.ShouldBindJSON(manifest); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid manifest: " + err.Error()})
 			return
 		}
-		defer file.Close()
 
-		buff := make([]byte, 512)
-		_, err = file.Read(buff)
+		missing, err := deployments.Missing(site, manifest)
 		if err != nil {
-			renderError(w, err, "error reading data", http.StatusBadRequest)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		_, err = file.Seek(0, io.SeekStart)
-		if err != nil {
-			renderError(w, err, "error reading data", http.StatusBadRequest)
+		c.JSON(http.StatusOK, gin.H{"missing": missing})
+	}
+}
+
+// Serves uploads, a multipart form with the files to publish as upload[]
+// parts. The form is processed as it arrives, writing every file straight
+// to the storage backend.
+//
+// The form may include a manifest, listing the files of the site. Files in
+// the manifest that are not uploaded are published from the content already
+// stored in the server. When the delete field is true, published files not
+// in the request are deleted. Both fields must precede the files.
+func (m *HTTPUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.maxUploadSize > 0 {
+		if r.ContentLength > m.maxUploadSize {
+			renderTooLarge(w, &sizeLimitError{"upload", m.maxUploadSize})
 			return
 		}
+		r.Body = &limitedReader{ReadCloser: r.Body, n: m.maxUploadSize, err: &sizeLimitError{"upload", m.maxUploadSize}}
+	}
 
-		err = os.MkdirAll(ddir, os.ModePerm)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "no files found in request", http.StatusBadRequest)
+		return
+	}
+
+	var manifest *deploy.Manifest
+	var staging *deploy.Staging
+	mirror := false
+	manifestFiles := map[string]*deploy.ManifestFile{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			renderError(w, err, "internal server error", http.StatusInternalServerError)
+			m.renderError(w, err, "error reading data", http.StatusBadRequest)
 			return
 		}
 
-		f, err := os.Create(dfile)
-		if err != nil {
-			renderError(w, err, "internal server error", http.StatusInternalServerError)
-			return
+		switch part.FormName() {
+		case "manifest":
+			manifest = &deploy.Manifest{}
+			err = json.NewDecoder(io.LimitReader(part, maxManifestSize)).Decode(manifest)
+			if err != nil {
+				m.renderError(w, err, "invalid manifest", http.StatusBadRequest)
+				return
+			}
+			for _, mf := range manifest.Files {
+				manifestFiles[mf.Path] = mf
+			}
+		case "delete":
+			value, err := ioutil.ReadAll(io.LimitReader(part, 16))
+			if err != nil {
+				m.renderError(w, err, "error reading data", http.StatusBadRequest)
+				return
+			}
+			mirror = string(value) == "true"
+		case "upload[]":
+			_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+			if err != nil {
+				renderError(w, err, "error opening data", http.StatusBadRequest)
+				return
+			}
+
+			if staging == nil {
+				staging = m.deployments.Stage(m.site, mirror)
+			}
+			path := params["filename"]
+			err = m.put(staging, path, part, manifestFiles[path])
+			if errors.Is(err, deploy.ErrChecksumMismatch) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				m.renderError(w, err, "internal server error", http.StatusInternalServerError)
+				return
+			}
 		}
-		defer f.Close()
+		part.Close()
+	}
 
-		_, err = io.Copy(f, file)
-		if err != nil {
-			renderError(w, err, "internal server error", http.StatusBadRequest)
+	if staging == nil {
+		if manifest == nil {
+			http.Error(w, "no files found in request", http.StatusBadRequest)
 			return
 		}
+		staging = m.deployments.Stage(m.site, mirror)
+	}
+
+	if manifest != nil {
+		for _, mf := range manifest.Files {
+			f, ok := staging.File(mf.Path)
+			if ok {
+				if f.SHA256 != mf.SHA256 {
+					http.Error(w, fmt.Sprintf("checksum mismatch for %s", mf.Path), http.StatusBadRequest)
+					return
+				}
+				continue
+			}
+
+			err := staging.Link(mf.Path, &deploy.File{SHA256: mf.SHA256, Size: mf.Size})
+			if err != nil {
+				renderError(w, err, "invalid manifest", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	d, err := staging.Commit()
+	if errors.Is(err, deploy.ErrMissingFile) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	if err != nil {
+		renderError(w, err, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	path := m.site
+	if name, err := m.registry.Name(m.site); err == nil && name != "" {
+		path = name
+	}
+
+	info := d.Info()
+	info.Live = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gin.H{"deployment": info, "deleted": staging.Deleted(), "site": path})
+}
+
+// Stores an uploaded file, verifying its content if it's in the manifest.
+func (m *HTTPUploads) put(staging *deploy.Staging, path string, part io.Reader, mf *deploy.ManifestFile) error {
+	if m.maxFileSize > 0 {
+		part = &limitedReader{
+			ReadCloser: ioutil.NopCloser(part),
+			n:          m.maxFileSize,
+			err:        &sizeLimitError{"file " + path, m.maxFileSize},
+		}
+	}
+
+	if mf != nil {
+		return staging.PutVerified(path, mf.SHA256, part)
+	}
+
+	return staging.Put(path, part)
+}
+
+// Renders errors caused by size limits with a 413 status, err with the given
+// message and status code otherwise.
+func (m *HTTPUploads) renderError(w http.ResponseWriter, err error, msg string, code int) {
+	var serr *sizeLimitError
+	if errors.As(err, &serr) {
+		renderTooLarge(w, serr)
+		return
+	}
+
+	renderError(w, err, msg, code)
+}
+
+func renderTooLarge(w http.ResponseWriter, err *sizeLimitError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(gin.H{"error": err.Error()})
 }
 
 func renderError(w http.ResponseWriter, err error, msg string, code int) {
 	http.Error(w, fmt.Sprintf("%s: %s", msg, err), code)
 }
+
+type sizeLimitError struct {
+	what  string
+	limit int64
+}
+
+func (e *sizeLimitError) Error() string {
+	return fmt.Sprintf("%s exceeds the maximum size of %s", e.what, humanize.Bytes(uint64(e.limit)))
+}
+
+// Reads up to n bytes, returning err if there's more data.
+type limitedReader struct {
+	io.ReadCloser
+	n   int64
+	err error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, l.err
+	}
+
+	// Read one byte past the limit to find out if there's more data
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.ReadCloser.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n + int(l.n), l.err
+	}
+
+	return n, err
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rubiojr/tavern/internal/deploy"
+	"github.com/rubiojr/tavern/internal/sites"
+	"github.com/rubiojr/tavern/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const charmID = "b4ede63d-c736-4561-80e9-0f912337b251"
+
+func TestUploadLimits(t *testing.T) {
+	store := storage.NewLocal(t.TempDir())
+	deployments := deploy.NewManager(store, 10)
+	registry := sites.NewRegistry(store)
+
+	tests := []struct {
+		name          string
+		maxFileSize   int64
+		maxUploadSize int64
+		// Streamed requests don't have a content length
+		streamed bool
+		status   int
+		body     string
+	}{
+		{"no limits", 0, 0, false, http.StatusOK, ""},
+		{"file within limit", 10, 0, false, http.StatusOK, ""},
+		{"file too large", 9, 0, false, http.StatusRequestEntityTooLarge, `{"error":"file /index.html exceeds the maximum size of 9 B"}` + "\n"},
+		{"upload too large", 0, 100, false, http.StatusRequestEntityTooLarge, `{"error":"upload exceeds the maximum size of 100 B"}` + "\n"},
+		{"streamed upload too large", 0, 100, true, http.StatusRequestEntityTooLarge, `{"error":"upload exceeds the maximum size of 100 B"}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("upload[]", "/index.html")
+			assert.NoError(t, err)
+			_, err = part.Write([]byte("0123456789"))
+			assert.NoError(t, err)
+			assert.NoError(t, writer.Close())
+
+			req := httptest.NewRequest(http.MethodPost, "/", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			if tt.streamed {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			handler := &HTTPUploads{deployments, registry, charmID, tt.maxFileSize, tt.maxUploadSize}
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.body != "" {
+				assert.Equal(t, tt.body, rec.Body.String())
+			}
+		})
+	}
+}
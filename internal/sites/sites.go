@@ -0,0 +1,298 @@
+// Package sites keeps the registry of site names, human friendly names
+// Charm accounts claim to serve their sites at /<name> instead of
+// /<charm-id> or /<charm-id>~<site>.
+//
+// Names are stored in a namespace reserved for the server, mapping every
+// name to its site and every site to its name.
+package sites
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rubiojr/tavern/server/storage"
+)
+
+// Storage namespace where site names are kept
+const Namespace = ".sites"
+
+const namesDir = "names/"
+
+// Site records, named after the site storage namespace
+const ownersDir = "owners/"
+
+// Time names looked up are cached, so serving files doesn't read the
+// registry on every request
+const cacheTTL = time.Minute
+
+// Maximum number of names cached
+const cacheSize = 1024
+
+// Names that can't be claimed, as they are or may be used by the server
+var reserved = map[string]struct{}{
+	"admin":   {},
+	"api":     {},
+	"assets":  {},
+	"favicon": {},
+	"health":  {},
+	"login":   {},
+	"robots":  {},
+	"sites":   {},
+	"static":  {},
+	"status":  {},
+	"tavern":  {},
+	"tokens":  {},
+	"v1":      {},
+	"v2":      {},
+	"www":     {},
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Charm IDs are UUIDs, names can't look like one
+var uuid = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// ErrTaken is returned when claiming a name owned by another Charm ID.
+var ErrTaken = errors.New("site name already taken")
+
+// ErrReserved is returned when claiming a reserved name.
+var ErrReserved = errors.New("site name is reserved")
+
+// Site is a site name claimed by a Charm ID for one of its sites.
+type Site struct {
+	Name    string `json:"name"`
+	CharmID string `json:"charm_id"`
+	// Storage namespace of the site
+	Site    string    `json:"site"`
+	Created time.Time `json:"created"`
+}
+
+type Registry struct {
+	store storage.Storage
+	// Serializes claims, so two Charm IDs can't claim the same name
+	mu    sync.Mutex
+	cmu   sync.Mutex
+	cache map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	site    *Site
+	expires time.Time
+}
+
+func NewRegistry(store storage.Storage) *Registry {
+	return &Registry{store: store, cache: map[string]*cacheEntry{}}
+}
+
+// ValidName returns an error if name can't be claimed: names are 1 to 63
+// lowercase letters, digits and dashes, not starting or ending with a dash.
+func ValidName(name string) error {
+	if !validName.MatchString(name) || uuid.MatchString(name) {
+		return fmt.Errorf("invalid site name %q, use 1 to 63 lowercase letters, digits and dashes", name)
+	}
+	if _, ok := reserved[name]; ok {
+		return ErrReserved
+	}
+
+	return nil
+}
+
+// Claim registers name for site, a site of charmID. Every site can have one
+// name, claiming the name a site already has is not an error.
+func (r *Registry) Claim(charmID, site, name string) (*Site, error) {
+	if err := ValidName(name); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	claimed, err := r.get(namesDir + name + ".json")
+	if err == nil {
+		if claimed.CharmID != charmID || claimed.Site != site {
+			return nil, ErrTaken
+		}
+		// Completes claims that failed writing the owner record
+		return claimed, r.put(ownersDir+site+".json", claimed)
+	}
+	if !errors.Is(err, storage.ErrNotExist) {
+		return nil, err
+	}
+
+	owned, err := r.Name(site)
+	if err != nil {
+		return nil, err
+	}
+	if owned != "" {
+		return nil, fmt.Errorf("the site already has the name %s, release it first", owned)
+	}
+
+	claimed = &Site{Name: name, CharmID: charmID, Site: site, Created: time.Now().UTC()}
+	// The owner record is written last, a failed claim leaves the name
+	// orphaned at worst, and the owner can claim it again
+	err = r.put(namesDir+name+".json", claimed)
+	if err != nil {
+		return nil, err
+	}
+	err = r.put(ownersDir+site+".json", claimed)
+	if err != nil {
+		return nil, err
+	}
+	r.cachePut(name, claimed)
+
+	return claimed, nil
+}
+
+// Release unregisters name, if owned by charmID, returning
+// storage.ErrNotExist otherwise.
+func (r *Registry) Release(charmID, name string) (*Site, error) {
+	if ValidName(name) != nil {
+		return nil, storage.ErrNotExist
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	site, err := r.get(namesDir + name + ".json")
+	if err != nil {
+		return nil, err
+	}
+	if site.CharmID != charmID {
+		return nil, storage.ErrNotExist
+	}
+
+	err = r.store.Delete(Namespace, ownersDir+site.Site+".json")
+	if err != nil {
+		return nil, err
+	}
+	err = r.store.Delete(Namespace, namesDir+name+".json")
+	if err != nil {
+		return nil, err
+	}
+	r.cachePut(name, nil)
+
+	return site, nil
+}
+
+// List returns the site names claimed by charmID, sorted by name.
+func (r *Registry) List(charmID string) ([]*Site, error) {
+	files, err := r.store.List(Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*Site{}
+	for _, fi := range files {
+		if !strings.HasPrefix(fi.Path, namesDir) {
+			continue
+		}
+		site, err := r.get(fi.Path)
+		if errors.Is(err, storage.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if site.CharmID == charmID {
+			list = append(list, site)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list, nil
+}
+
+// Name returns the name of site, or an empty string if it doesn't have one.
+func (r *Registry) Name(site string) (string, error) {
+	claimed, err := r.get(ownersDir + site + ".json")
+	if errors.Is(err, storage.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return claimed.Name, nil
+}
+
+// Lookup returns the storage namespace of the site named name, or
+// storage.ErrNotExist if the name wasn't claimed.
+func (r *Registry) Lookup(name string) (string, error) {
+	if !validName.MatchString(name) || uuid.MatchString(name) {
+		return "", storage.ErrNotExist
+	}
+
+	r.cmu.Lock()
+	e, ok := r.cache[name]
+	r.cmu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		if e.site == nil {
+			return "", storage.ErrNotExist
+		}
+		return e.site.Site, nil
+	}
+
+	site, err := r.get(namesDir + name + ".json")
+	if errors.Is(err, storage.ErrNotExist) {
+		r.cachePut(name, nil)
+		return "", err
+	}
+	if err != nil {
+		return "", err
+	}
+	r.cachePut(name, site)
+
+	return site.Site, nil
+}
+
+func (r *Registry) cachePut(name string, site *Site) {
+	r.cmu.Lock()
+	defer r.cmu.Unlock()
+
+	if _, ok := r.cache[name]; !ok && len(r.cache) >= cacheSize {
+		for k := range r.cache {
+			delete(r.cache, k)
+			break
+		}
+	}
+	r.cache[name] = &cacheEntry{site: site, expires: time.Now().Add(cacheTTL)}
+}
+
+func (r *Registry) get(path string) (*Site, error) {
+	rd, err := r.store.Get(Namespace, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	site := &Site{}
+	err = json.NewDecoder(rd).Decode(site)
+	if err != nil {
+		return nil, fmt.Errorf("invalid site %s: %w", path, err)
+	}
+	// Names claimed before accounts had several sites name the default site
+	if site.Site == "" {
+		site.Site = site.CharmID
+	}
+
+	return site, nil
+}
+
+func (r *Registry) put(path string, site *Site) error {
+	data, err := json.Marshal(site)
+	if err != nil {
+		return err
+	}
+
+	return r.store.Put(Namespace, path, bytes.NewReader(data))
+}
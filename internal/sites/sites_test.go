@@ -0,0 +1,83 @@
+package sites
+
+import (
+	"testing"
+
+	"github.com/rubiojr/tavern/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const charmID = "b4ede63d-c736-4561-80e9-0f912337b251"
+const other = "0a1b2c3d-0000-4000-8000-000000000000"
+
+func TestClaim(t *testing.T) {
+	r := NewRegistry(storage.NewLocal(t.TempDir()))
+
+	_, err := r.Lookup("docs")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	site, err := r.Claim(charmID, charmID, "docs")
+	assert.NoError(t, err)
+	assert.Equal(t, "docs", site.Name)
+	ns, err := r.Lookup("docs")
+	assert.NoError(t, err)
+	assert.Equal(t, charmID, ns)
+	name, err := r.Name(charmID)
+	assert.NoError(t, err)
+	assert.Equal(t, "docs", name)
+
+	_, err = r.Claim(charmID, charmID, "docs")
+	assert.NoError(t, err, "claiming an owned name again")
+	_, err = r.Claim(other, other, "docs")
+	assert.ErrorIs(t, err, ErrTaken)
+	_, err = r.Claim(charmID, charmID+"~blog", "docs")
+	assert.ErrorIs(t, err, ErrTaken)
+	_, err = r.Claim(charmID, charmID, "blog")
+	assert.EqualError(t, err, "the site already has the name docs, release it first")
+
+	for _, name := range []string{"", "Docs", "-docs", "docs-", "my_site", ".tokens", "a/b", charmID} {
+		_, err = r.Claim(other, other, name)
+		assert.Error(t, err, name)
+	}
+	_, err = r.Claim(other, other, "v1")
+	assert.ErrorIs(t, err, ErrReserved)
+
+	_, err = r.Release(other, "docs")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	_, err = r.Release(charmID, "docs")
+	assert.NoError(t, err)
+	_, err = r.Lookup("docs")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	_, err = r.Claim(other, other, "docs")
+	assert.NoError(t, err)
+	ns, err = r.Lookup("docs")
+	assert.NoError(t, err)
+	assert.Equal(t, other, ns)
+}
+
+func TestNamedSites(t *testing.T) {
+	r := NewRegistry(storage.NewLocal(t.TempDir()))
+
+	_, err := r.Claim(charmID, charmID, "docs")
+	assert.NoError(t, err)
+	_, err = r.Claim(charmID, charmID+"~blog", "blog")
+	assert.NoError(t, err)
+
+	ns, err := r.Lookup("blog")
+	assert.NoError(t, err)
+	assert.Equal(t, charmID+"~blog", ns)
+
+	list, err := r.List(charmID)
+	assert.NoError(t, err)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "blog", list[0].Name)
+		assert.Equal(t, charmID+"~blog", list[0].Site)
+		assert.Equal(t, "docs", list[1].Name)
+		assert.Equal(t, charmID, list[1].Site)
+	}
+
+	list, err = r.List(other)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+}
@@ -0,0 +1,143 @@
+package testutil
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In-memory S3 compatible server, good enough to test Tavern's S3 storage.
+type FakeS3 struct {
+	*httptest.Server
+	objects map[string]*fakeObject
+	m       sync.Mutex
+}
+
+type fakeObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+type fakeListResult struct {
+	XMLName  xml.Name `xml:"ListBucketResult"`
+	Contents []fakeListObject
+}
+
+type fakeCopyResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	LastModified time.Time
+}
+
+type fakeListObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+func NewFakeS3() *FakeS3 {
+	f := &FakeS3{objects: map[string]*fakeObject{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+// Returns the object keys stored in the bucket, sorted.
+func (f *FakeS3) Keys(bucket string) []string {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	keys := []string{}
+	for k := range f.objects {
+		if strings.HasPrefix(k, bucket+"/") {
+			keys = append(keys, strings.TrimPrefix(k, bucket+"/"))
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (f *FakeS3) handle(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			f.copy(w, key, src)
+			return
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = &fakeObject{data: data, modTime: time.Now()}
+	case http.MethodGet, http.MethodHead:
+		if r.URL.Query().Get("list-type") == "2" {
+			f.list(w, key, r.URL.Query().Get("prefix"))
+			return
+		}
+		obj, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *FakeS3) copy(w http.ResponseWriter, key, src string) {
+	src, err := url.PathUnescape(src)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	obj, ok := f.objects[strings.TrimPrefix(src, "/")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.objects[key] = &fakeObject{data: obj.data, modTime: time.Now()}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(&fakeCopyResult{LastModified: time.Now()})
+}
+
+func (f *FakeS3) list(w http.ResponseWriter, bucket, prefix string) {
+	result := &fakeListResult{}
+	for k, obj := range f.objects {
+		if !strings.HasPrefix(k, bucket+"/"+prefix) {
+			continue
+		}
+		result.Contents = append(result.Contents, fakeListObject{
+			Key:          strings.TrimPrefix(k, bucket+"/"),
+			Size:         int64(len(obj.data)),
+			LastModified: obj.modTime,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
@@ -11,7 +12,9 @@ import (
 	"time"
 
 	"github.com/charmbracelet/charm/client"
+	cfs "github.com/charmbracelet/charm/fs"
 	ts "github.com/rubiojr/tavern/server"
+	"github.com/rubiojr/tavern/server/storage"
 )
 
 // Tavern testing server
@@ -48,6 +51,29 @@ func (b *Buffer) String() string {
 	return b.b.String()
 }
 
+// Writes a file with the given content to CharmFS
+func WriteCharmFile(charmfs *cfs.FS, name, content string) error {
+	tdir, err := ioutil.TempDir("", "tavern-test-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tdir)
+
+	src := filepath.Join(tdir, filepath.Base(name))
+	err = ioutil.WriteFile(src, []byte(content), 0644)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return charmfs.WriteFile(name, f)
+}
+
 func CharmClient() (*client.Client, error) {
 	cconfig, err := client.ConfigFromEnv()
 	if err != nil {
@@ -67,6 +93,9 @@ func CharmClient() (*client.Client, error) {
 }
 
 func TavernServer(ctx context.Context, dataDir string) (*ts.Server, error) {
+	// Previous test servers may still be shutting down
+	WaitForServerShutdown(TestServerAddr)
+
 	tav := ts.NewServerWithConfig(&ts.Config{
 		Addr:        TestServerAddr,
 		UploadsPath: filepath.Join(dataDir, UploadsPath),
@@ -87,6 +116,9 @@ func TavernServer(ctx context.Context, dataDir string) (*ts.Server, error) {
 
 // Start a Tavern server with an allowed list of Charm servers
 func TavernServerA(ctx context.Context, dataDir string, allowList ...string) (*ts.Server, error) {
+	// Previous test servers may still be shutting down
+	WaitForServerShutdown(TestServerAddr)
+
 	tav := ts.NewServerWithConfig(&ts.Config{
 		Addr:                TestServerAddr,
 		UploadsPath:         filepath.Join(dataDir, UploadsPath),
@@ -101,6 +133,34 @@ func TavernServerA(ctx context.Context, dataDir string, allowList ...string) (*t
 	return tav, nil
 }
 
+// Start a Tavern server storing files in a S3 bucket
+func TavernServerS3(ctx context.Context, endpoint, bucket string) (*ts.Server, error) {
+	store, err := storage.NewS3(&storage.S3Config{
+		Endpoint:  endpoint,
+		Bucket:    bucket,
+		AccessKey: "test",
+		SecretKey: "test",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Previous test servers may still be shutting down
+	WaitForServerShutdown(TestServerAddr)
+
+	tav := ts.NewServerWithConfig(&ts.Config{
+		Addr:    TestServerAddr,
+		Storage: store,
+	})
+	go tav.Serve(ctx)
+
+	if !WaitForServer(TestServerAddr) {
+		return nil, fmt.Errorf("tavern server did not start")
+	}
+
+	return tav, nil
+}
+
 func WaitForServerShutdown(addr string) bool {
 	for i := 0; i < 40; i++ {
 		_, err := net.Dial("tcp", addr)
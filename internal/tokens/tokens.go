@@ -0,0 +1,216 @@
+// Package tokens manages deploy tokens, secrets issued by the server that
+// publish to a site without a Charm account, for CI runners and other
+// machines with no Charm keys.
+//
+// Only the SHA-256 hash of a token is stored, in a namespace reserved for
+// the server, so tokens can't be recovered from the storage backend.
+package tokens
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rubiojr/tavern/server/storage"
+)
+
+// Storage namespace where tokens are kept
+const Namespace = ".tokens"
+
+// Prefix of every deploy token, telling them apart from Charm JWTs
+const Prefix = "tvn_"
+
+// Longest lifetime of a token
+const MaxTTL = 365 * 24 * time.Hour
+
+// Maximum number of tokens per Charm ID
+const MaxTokens = 100
+
+const maxNameLength = 64
+
+// ErrInvalid is returned when looking up a token that doesn't exist, was
+// revoked or has expired.
+var ErrInvalid = errors.New("invalid deploy token")
+
+// Token is a deploy token, publishing to Site on behalf of CharmID until it
+// expires.
+type Token struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	CharmID string    `json:"charm_id"`
+	Site    string    `json:"site"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	// Path in the storage backend, named after the token hash
+	path string
+}
+
+func (t *Token) Expired() bool {
+	return !time.Now().Before(t.Expires)
+}
+
+type Store struct {
+	store storage.Storage
+}
+
+func NewStore(store storage.Storage) *Store {
+	return &Store{store: store}
+}
+
+// Create issues a token for charmID publishing to site, valid until
+// expires. Returns the token and its secret, that can't be retrieved
+// again.
+func (s *Store) Create(charmID, site, name string, expires time.Time) (*Token, string, error) {
+	if len(name) > maxNameLength {
+		return nil, "", fmt.Errorf("token names can't be longer than %d characters", maxNameLength)
+	}
+
+	now := time.Now().UTC()
+	if !expires.After(now) {
+		return nil, "", fmt.Errorf("token expiration must be in the future")
+	}
+	if expires.Sub(now) > MaxTTL {
+		return nil, "", fmt.Errorf("tokens can't be valid for longer than %d days", MaxTTL/(24*time.Hour))
+	}
+
+	tokens, err := s.List(charmID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(tokens) >= MaxTokens {
+		return nil, "", fmt.Errorf("too many tokens, revoke some first")
+	}
+
+	id, err := randomHex(4)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	secret = Prefix + secret
+
+	t := &Token{ID: id, Name: name, CharmID: charmID, Site: site, Created: now, Expires: expires.UTC(), path: tokenPath(secret)}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = s.store.Put(Namespace, t.path, bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return t, secret, nil
+}
+
+// Lookup returns the token with the given secret, or ErrInvalid.
+func (s *Store) Lookup(secret string) (*Token, error) {
+	if !strings.HasPrefix(secret, Prefix) {
+		return nil, ErrInvalid
+	}
+
+	t, err := s.get(tokenPath(secret))
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, ErrInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Expired() {
+		s.store.Delete(Namespace, t.path)
+		return nil, ErrInvalid
+	}
+
+	return t, nil
+}
+
+// List returns the tokens of charmID, oldest first. Expired tokens are
+// removed.
+func (s *Store) List(charmID string) ([]*Token, error) {
+	files, err := s.store.List(Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []*Token{}
+	for _, fi := range files {
+		t, err := s.get(fi.Path)
+		if errors.Is(err, storage.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t.CharmID != charmID {
+			continue
+		}
+		if t.Expired() {
+			s.store.Delete(Namespace, t.path)
+			continue
+		}
+		tokens = append(tokens, t)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+
+	return tokens, nil
+}
+
+// Revoke deletes the token of charmID with the given ID, returning
+// storage.ErrNotExist if there's no such token.
+func (s *Store) Revoke(charmID, id string) (*Token, error) {
+	tokens, err := s.List(charmID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tokens {
+		if t.ID == id {
+			return t, s.store.Delete(Namespace, t.path)
+		}
+	}
+
+	return nil, storage.ErrNotExist
+}
+
+func (s *Store) get(path string) (*Token, error) {
+	r, err := s.store.Get(Namespace, path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	t := &Token{path: path}
+	err = json.NewDecoder(r).Decode(t)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token %s: %w", path, err)
+	}
+
+	return t, nil
+}
+
+func tokenPath(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,72 @@
+package tokens
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rubiojr/tavern/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const charmID = "b4ede63d-c736-4561-80e9-0f912337b251"
+
+func TestTokens(t *testing.T) {
+	store := storage.NewLocal(t.TempDir())
+	s := NewStore(store)
+
+	tok, secret, err := s.Create(charmID, charmID, "ci", time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", tok.Name)
+	assert.Regexp(t, "^tvn_[0-9a-f]{64}$", secret)
+
+	found, err := s.Lookup(secret)
+	assert.NoError(t, err)
+	assert.Equal(t, tok.ID, found.ID)
+	assert.Equal(t, charmID, found.Site)
+
+	_, err = s.Lookup(secret + "0")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// Only the hash of the secret is stored
+	files, err := store.List(Namespace)
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		assert.NotContains(t, files[0].Path, secret[len(Prefix):])
+	}
+
+	_, _, err = s.Create("other", "other", "", time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	tokens, err := s.List(charmID)
+	assert.NoError(t, err)
+	if assert.Len(t, tokens, 1) {
+		assert.Equal(t, tok.ID, tokens[0].ID)
+	}
+
+	_, err = s.Revoke("other", tok.ID)
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+	_, err = s.Revoke(charmID, tok.ID)
+	assert.NoError(t, err)
+	_, err = s.Lookup(secret)
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestTokenExpiration(t *testing.T) {
+	s := NewStore(storage.NewLocal(t.TempDir()))
+
+	_, _, err := s.Create(charmID, charmID, "", time.Now().Add(-time.Second))
+	assert.Error(t, err)
+	_, _, err = s.Create(charmID, charmID, "", time.Now().Add(MaxTTL+time.Hour))
+	assert.Error(t, err)
+
+	_, secret, err := s.Create(charmID, charmID, "", time.Now().Add(100*time.Millisecond))
+	assert.NoError(t, err)
+	_, err = s.Lookup(secret)
+	assert.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+	_, err = s.Lookup(secret)
+	assert.ErrorIs(t, err, ErrInvalid)
+	tokens, err := s.List(charmID)
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+}
@@ -7,29 +7,60 @@ import (
 	"os"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rubiojr/tavern/internal/deploy"
 	"github.com/rubiojr/tavern/internal/middleware"
+	"github.com/rubiojr/tavern/internal/sites"
+	"github.com/rubiojr/tavern/internal/tokens"
+	"github.com/rubiojr/tavern/server/storage"
 )
 
 const UploadRoute = "/v1/tavern/upload"
+const RollbackRoute = "/v1/tavern/rollback"
+const DeploymentsRoute = "/v1/tavern/deployments"
+const ManifestRoute = "/v1/tavern/manifest"
+const BlobsRoute = "/v1/tavern/blobs"
+const TokensRoute = "/v1/tavern/tokens"
+const SitesRoute = "/v1/tavern/sites"
 const ServerDefaultUploadsPath = "tavern_uploads"
 const ServerDefaultAddr = "127.0.0.1:8000"
 const ServerDefaultURL = "http://" + ServerDefaultAddr
 const ServerDefaultCharmServerURL = "https://cloud.charm.sh:35354"
+const ServerDefaultKeepDeployments = 10
 
 type Config struct {
 	Addr                string
 	UploadsPath         string
 	AllowedCharmServers []string
+	// Charm IDs allowed to publish, any if empty, and Charm IDs that can't
+	// publish
+	AllowedCharmIDs []string
+	DeniedCharmIDs  []string
+	// File listing allowed Charm IDs, and denied ones prefixed with "!",
+	// read again when it changes
+	CharmIDsFile string
+	// Number of deployments kept per Charm ID, to roll back to
+	KeepDeployments int
+	// Maximum size in bytes of every file published, zero for no limit
+	MaxFileSize int64
+	// Maximum size in bytes of a publish request, zero for no limit
+	MaxUploadSize int64
+	// Storage backend for published files. Defaults to the local
+	// filesystem, under UploadsPath.
+	Storage storage.Storage
 }
 
 type Server struct {
 	config *Config
+	// Charm server keys used to validate publishing tokens, shared by every
+	// request
+	keys *middleware.KeyCache
 }
 
 func NewServer() *Server {
 	config := &Config{
-		Addr:        ServerDefaultAddr,
-		UploadsPath: ServerDefaultUploadsPath,
+		Addr:            ServerDefaultAddr,
+		UploadsPath:     ServerDefaultUploadsPath,
+		KeepDeployments: ServerDefaultKeepDeployments,
 	}
 
 	return NewServerWithConfig(config)
@@ -44,27 +75,69 @@ func NewServerWithConfig(config *Config) *Server {
 		config.Addr = ServerDefaultAddr
 	}
 
-	return &Server{config: config}
+	if config.KeepDeployments == 0 {
+		config.KeepDeployments = ServerDefaultKeepDeployments
+	}
+
+	if config.Storage == nil {
+		config.Storage = storage.NewLocal(config.UploadsPath)
+	}
+
+	return &Server{config: config, keys: middleware.NewKeyCache(middleware.DefaultKeyCacheSize)}
 }
 
 func (s *Server) Serve(ctx context.Context) error {
-	err := os.MkdirAll(s.config.UploadsPath, 0755)
-	if err != nil {
-		return err
+	if local, ok := s.config.Storage.(*storage.Local); ok {
+		err := os.MkdirAll(local.Root(), 0755)
+		if err != nil {
+			return err
+		}
 	}
 
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
-	uploads := router.Group(UploadRoute)
 	allowedServers := map[string]struct{}{}
 	for _, host := range s.config.AllowedCharmServers {
 		allowedServers[host] = struct{}{}
 	}
-	uploads.Use(middleware.JWKS(allowedServers))
-	uploads.POST("/", middleware.Uploads(s.config.UploadsPath, 32<<20))
-	router.StaticFS("/", http.Dir(s.config.UploadsPath))
+	ids, err := middleware.NewCharmIDs(s.config.AllowedCharmIDs, s.config.DeniedCharmIDs, s.config.CharmIDsFile)
+	if err != nil {
+		return err
+	}
+	auth := middleware.JWKS(allowedServers, ids, s.keys)
+	deployTokens := tokens.NewStore(s.config.Storage)
+	// Deploy tokens can only publish, managing tokens and deployments
+	// requires a Charm account
+	publish := middleware.DeployTokens(deployTokens, ids, auth)
+	// Routes acting on a site of the account, the one in the site query
+	// parameter or the default one
+	site := middleware.Site()
+	deployments := deploy.NewManager(s.config.Storage, s.config.KeepDeployments)
+	registry := sites.NewRegistry(s.config.Storage)
+	uploads := router.Group(UploadRoute)
+	uploads.Use(publish, site)
+	uploadsHandler := middleware.Uploads(deployments, registry, s.config.MaxFileSize, s.config.MaxUploadSize)
+	uploads.POST("", uploadsHandler)
+	uploads.POST("/", uploadsHandler)
+	router.POST(ManifestRoute, publish, site, middleware.Manifest(deployments))
+	router.HEAD(BlobsRoute+"/:sha256", publish, site, middleware.UploadOffset(deployments))
+	router.PATCH(BlobsRoute+"/:sha256", publish, site, middleware.UploadChunk(deployments, s.config.MaxFileSize, s.config.MaxUploadSize))
+	router.POST(RollbackRoute, auth, site, middleware.Rollback(deployments))
+	router.GET(DeploymentsRoute, auth, site, middleware.Deployments(deployments))
+	router.GET(TokensRoute, auth, middleware.Tokens(deployTokens))
+	router.POST(TokensRoute, auth, site, middleware.CreateToken(deployTokens))
+	router.DELETE(TokensRoute+"/:id", auth, middleware.RevokeToken(deployTokens))
+	router.GET(SitesRoute, auth, middleware.Sites(registry))
+	router.POST(SitesRoute, auth, site, middleware.ClaimSite(registry))
+	router.DELETE(SitesRoute+"/:name", auth, middleware.ReleaseSite(registry))
+	router.NoRoute(middleware.Files(deployments, registry))
 	log.Printf("serving on: %s", s.config.Addr)
-	log.Printf("uploads directory: %s", s.config.UploadsPath)
+	switch st := s.config.Storage.(type) {
+	case *storage.Local:
+		log.Printf("uploads directory: %s", st.Root())
+	case *storage.S3:
+		log.Printf("uploads bucket: %s", st.Bucket())
+	}
 
 	srv := &http.Server{
 		Addr:    s.config.Addr,
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Local stores files in the local filesystem, under a root directory with
+// one subdirectory per Charm ID.
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// Root returns the directory where files are stored.
+func (l *Local) Root() string {
+	return l.root
+}
+
+func (l *Local) Put(charmID, path string, r io.Reader) error {
+	dfile, err := l.filePath(charmID, path)
+	if err != nil {
+		return err
+	}
+
+	ddir := filepath.Dir(dfile)
+	err = os.MkdirAll(ddir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first and rename it, so readers never see
+	// a partially written file.
+	f, err := ioutil.TempFile(ddir, ".tavern-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(f.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), dfile)
+}
+
+func (l *Local) Get(charmID, path string) (io.ReadCloser, error) {
+	dfile, err := l.filePath(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := l.Stat(charmID, path); err != nil {
+		return nil, err
+	}
+
+	return os.Open(dfile)
+}
+
+func (l *Local) Stat(charmID, path string) (*FileInfo, error) {
+	dfile, err := l.filePath(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(dfile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Directories are an implementation detail of this backend
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: ErrNotExist}
+	}
+
+	cpath, _ := CleanPath(path)
+	return &FileInfo{Path: cpath, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) List(charmID string) ([]*FileInfo, error) {
+	if err := validCharmID(charmID); err != nil {
+		return nil, err
+	}
+
+	files := []*FileInfo{}
+	dir := filepath.Join(l.root, charmID)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, &FileInfo{Path: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+
+	return files, err
+}
+
+func (l *Local) Delete(charmID, path string) error {
+	dfile, err := l.filePath(charmID, path)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(dfile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	l.removeEmptyDirs(charmID, dfile)
+
+	return nil
+}
+
+func (l *Local) Rename(charmID, from, to string) error {
+	src, err := l.filePath(charmID, from)
+	if err != nil {
+		return err
+	}
+	dst, err := l.filePath(charmID, to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.Stat(charmID, from); err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(src, dst)
+	if err != nil {
+		return err
+	}
+	l.removeEmptyDirs(charmID, src)
+
+	return nil
+}
+
+// Removes the parent directories of dfile left empty, up to the Charm ID
+// directory.
+func (l *Local) removeEmptyDirs(charmID, dfile string) {
+	top := filepath.Join(l.root, charmID)
+	for dir := filepath.Dir(dfile); dir != top; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+}
+
+func (l *Local) filePath(charmID, path string) (string, error) {
+	if err := validCharmID(charmID); err != nil {
+		return "", err
+	}
+
+	p, err := CleanPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(l.root, charmID, filepath.FromSlash(p)), nil
+}
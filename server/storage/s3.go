@@ -0,0 +1,450 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const S3DefaultRegion = "us-east-1"
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type S3Config struct {
+	// S3 API endpoint, e.g. https://s3.eu-west-1.amazonaws.com or the URL
+	// of a MinIO server. Defaults to the AWS endpoint for Region.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Optional key prefix for every object stored
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3 stores files in an S3 compatible object storage bucket, one object
+// per file, with keys like <prefix>/<charm-id>/<path>.
+//
+// Buckets are addressed path-style, which is supported by AWS and most
+// S3 compatible servers.
+type S3 struct {
+	config *S3Config
+	client *http.Client
+}
+
+func NewS3(cfg *S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("S3 bucket required")
+	}
+
+	c := *cfg
+	if c.Region == "" {
+		c.Region = S3DefaultRegion
+	}
+
+	if c.Endpoint == "" {
+		c.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", c.Region)
+	}
+	c.Endpoint = strings.TrimRight(c.Endpoint, "/")
+	if _, err := url.Parse(c.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	c.Prefix = strings.Trim(c.Prefix, "/")
+
+	return &S3{config: &c, client: &http.Client{}}, nil
+}
+
+// Bucket returns the name of the bucket where files are stored.
+func (s *S3) Bucket() string {
+	return s.config.Bucket
+}
+
+func (s *S3) Put(charmID, path string, r io.Reader) error {
+	key, err := s.key(charmID, path)
+	if err != nil {
+		return err
+	}
+
+	// S3 requires the content length upfront
+	size, err := readerSize(r)
+	if err != nil {
+		tmp, err := ioutil.TempFile("", "tavern-s3-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if size, err = io.Copy(tmp, r); err != nil {
+			return err
+		}
+		if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = tmp
+	}
+
+	req, err := s.request(http.MethodPut, key, nil, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := s.do(req, "UNSIGNED-PAYLOAD")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (s *S3) Get(charmID, path string) (io.ReadCloser, error) {
+	key, err := s.key(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s.request(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *S3) Stat(charmID, path string) (*FileInfo, error) {
+	key, err := s.key(charmID, path)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := s.request(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	mtime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	cpath, _ := CleanPath(path)
+	return &FileInfo{Path: cpath, Size: resp.ContentLength, ModTime: mtime}, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *S3) List(charmID string) ([]*FileInfo, error) {
+	prefix, err := s.key(charmID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*FileInfo{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := s.request(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := s.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &listBucketResult{}
+		err = xml.NewDecoder(resp.Body).Decode(result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range result.Contents {
+			files = append(files, &FileInfo{
+				Path:    strings.TrimPrefix(obj.Key, prefix),
+				Size:    obj.Size,
+				ModTime: obj.LastModified,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) Delete(charmID, path string) error {
+	key, err := s.key(charmID, path)
+	if err != nil {
+		return err
+	}
+
+	req, err := s.request(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return err
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	return nil
+}
+
+// Rename copies the object server side and deletes the source, S3 has no
+// rename operation.
+func (s *S3) Rename(charmID, from, to string) error {
+	src, err := s.key(charmID, from)
+	if err != nil {
+		return err
+	}
+	dst, err := s.key(charmID, to)
+	if err != nil {
+		return err
+	}
+
+	req, err := s.request(http.MethodPut, dst, nil, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Amz-Copy-Source", awsURIEncode("/"+s.config.Bucket+"/"+src, false))
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Copies can fail after the response status is sent, the error is
+	// reported in the body instead
+	var result struct {
+		XMLName xml.Name
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("S3 copy of %s failed: %w", src, err)
+	}
+	if result.XMLName.Local != "CopyObjectResult" {
+		return fmt.Errorf("S3 copy of %s failed: unexpected %s response", src, result.XMLName.Local)
+	}
+
+	return s.Delete(charmID, from)
+}
+
+// Returns the object key for path, or the key prefix for charmID if path is
+// empty.
+func (s *S3) key(charmID, path string) (string, error) {
+	if err := validCharmID(charmID); err != nil {
+		return "", err
+	}
+
+	key := charmID + "/"
+	if s.config.Prefix != "" {
+		key = s.config.Prefix + "/" + key
+	}
+
+	if path == "" {
+		return key, nil
+	}
+
+	p, err := CleanPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	return key + p, nil
+}
+
+func (s *S3) request(method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(s.config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path += "/" + s.config.Bucket + "/" + key
+	u.RawPath = awsURIEncode(u.Path, false)
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+
+	return http.NewRequest(method, u.String(), body)
+}
+
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	signV4(req, payloadHash, s.config.AccessKey, s.config.SecretKey, s.config.Region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &fs.PathError{Op: strings.ToLower(req.Method), Path: req.URL.Path, Err: ErrNotExist}
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("S3 %s %s failed with status %d: %s", req.Method, req.URL.Path, resp.StatusCode, msg)
+}
+
+func readerSize(r io.Reader) (int64, error) {
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("reader size unknown")
+	}
+
+	cur, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = seeker.Seek(cur, io.SeekStart)
+	return end - cur, err
+}
+
+// Signs req using AWS Signature Version 4, with the host and x-amz-* headers
+// signed.
+func signV4(req *http.Request, payloadHash, accessKey, secretKey, region string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	cquery := []string{}
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			cquery = append(cquery, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	uri := req.URL.Path
+	if uri == "" {
+		uri = "/"
+	}
+
+	// S3 requires every x-amz-* header sent to be signed
+	headers := []string{"host"}
+	for k := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			headers = append(headers, k)
+		}
+	}
+	sort.Strings(headers)
+	cheaders := []string{}
+	for _, k := range headers {
+		v := host
+		if k != "host" {
+			v = strings.TrimSpace(req.Header.Get(k))
+		}
+		cheaders = append(cheaders, k+":"+v)
+	}
+
+	signedHeaders := strings.Join(headers, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(uri, false),
+		strings.Join(cquery, "&"),
+		strings.Join(cheaders, "\n"),
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// URI encodes s following the AWS SigV4 rules.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(strconv.FormatInt(int64(c)|0x100, 16)[1:]))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,89 @@
+// Package storage provides the backends a Tavern server uses to store and
+// retrieve published files.
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned when a file is not found in the storage backend.
+var ErrNotExist = fs.ErrNotExist
+
+// SiteSeparator separates the Charm ID and the site name in the namespace
+// of named sites.
+const SiteSeparator = "~"
+
+var validSite = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Storage stores published files, namespaced by the Charm ID of the
+// publisher, or by site for its named sites (see SiteNamespace).
+//
+// Paths are slash separated and relative to the Charm ID namespace.
+type Storage interface {
+	// Put writes the contents of r to path, replacing any existing file.
+	Put(charmID, path string, r io.Reader) error
+	// Get opens path for reading. The caller must close the returned reader.
+	Get(charmID, path string) (io.ReadCloser, error)
+	// Stat returns information about path, or ErrNotExist.
+	Stat(charmID, path string) (*FileInfo, error)
+	// List returns every file stored for charmID.
+	List(charmID string) ([]*FileInfo, error)
+	// Delete removes path. Deleting a missing file is not an error.
+	Delete(charmID, path string) error
+	// Rename moves the file at from to to, replacing any existing file.
+	Rename(charmID, from, to string) error
+}
+
+// FileInfo describes a stored file.
+type FileInfo struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// CleanPath normalizes a slash separated path so it can't escape the
+// Charm ID namespace.
+func CleanPath(p string) (string, error) {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "", fmt.Errorf("invalid path")
+	}
+
+	return p, nil
+}
+
+func validCharmID(charmID string) error {
+	if charmID == "" || charmID == "." || charmID == ".." || strings.ContainsAny(charmID, `/\`) {
+		return fmt.Errorf("invalid charm ID %q", charmID)
+	}
+
+	return nil
+}
+
+// Reserved reports whether charmID is a namespace holding the server's own
+// data, like deploy tokens, that can't be published to or served. Reserved
+// namespaces start with a dot, Charm IDs never do.
+func Reserved(charmID string) bool {
+	return strings.HasPrefix(charmID, ".")
+}
+
+// SiteNamespace returns the namespace of the files of a site of charmID:
+// the Charm ID for its default site, with no name, or <charm-id>~<site> for
+// named sites. Site names are 1 to 63 lowercase letters, digits and dashes.
+func SiteNamespace(charmID, site string) (string, error) {
+	if site == "" {
+		return charmID, nil
+	}
+
+	if !validSite.MatchString(site) {
+		return "", fmt.Errorf("invalid site %q, use 1 to 63 lowercase letters, digits and dashes", site)
+	}
+
+	return charmID + SiteSeparator + site, nil
+}